@@ -0,0 +1,399 @@
+// Package config loads everything a service can be configured with.  Each
+// setting comes from, in increasing order of precedence, its built in
+// default, an optional YAML file, an environment variable and a command
+// line flag.  The result is checked before the service starts.  The three
+// services share this loader, a Service says where they differ
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"drexel.edu/common/logging"
+	"drexel.edu/common/tracing"
+	"gopkg.in/yaml.v3"
+)
+
+// The stores a service can keep its data in
+const (
+	StoreRedis  = "redis"
+	StoreMemory = "memory"
+	StoreSQLite = "sqlite"
+)
+
+// DefaultRedisAddr is where redis is looked for unless told otherwise
+const DefaultRedisAddr = "0.0.0.0:6379"
+
+// The other services a service can call, as named in Service.Calls
+const (
+	VotesAPI = "votesapi"
+	VoterAPI = "voterapi"
+	PollAPI  = "pollapi"
+)
+
+// Service is what differs between the services' configuration
+type Service struct {
+	//What the store keeps, like polls, for the -help text
+	Keeps string
+	//The database file the sqlite store uses by default
+	SQLitePath string
+	//The other services this one calls, with the URL each is found at by
+	//default.  Only these get flags and environment variables
+	Calls map[string]string
+	//Older names for -redis and REDIS_URL that are still accepted, if any
+	RedisFlagAlias string
+	RedisEnvAlias  string
+}
+
+// Config is a service's configuration.  The yaml tags are the keys used in
+// the config file
+type Config struct {
+	Host string `yaml:"host"`
+	Port uint   `yaml:"port"`
+
+	Store      string      `yaml:"store"`
+	SQLitePath string      `yaml:"sqlitePath"`
+	Redis      RedisConfig `yaml:"redis"`
+
+	//Only the URLs of services in Service.Calls are used
+	VotesAPIURL string `yaml:"votesApiUrl,omitempty"`
+	VoterAPIURL string `yaml:"voterApiUrl,omitempty"`
+	PollAPIURL  string `yaml:"pollApiUrl,omitempty"`
+
+	Timeouts Timeouts `yaml:"timeouts"`
+
+	LogFormat  string `yaml:"logFormat"`
+	LogLevel   string `yaml:"logLevel"`
+	Traces     string `yaml:"traces"`
+	TracesFile string `yaml:"tracesFile"`
+
+	//Where the config was read from, and whether to print it and exit.
+	//These only come from flags and the environment
+	File        string `yaml:"-"`
+	PrintConfig bool   `yaml:"-"`
+
+	service Service
+}
+
+// RedisConfig says how to reach redis.  KeyPrefix is put in front of every
+// key the store uses, so several deployments can share one redis.  The
+// services of one deployment need the same prefix, since they share the
+// domain event stream
+type RedisConfig struct {
+	Addr      string `yaml:"addr"`
+	Password  string `yaml:"password"`
+	DB        int    `yaml:"db"`
+	TLS       bool   `yaml:"tls"`
+	KeyPrefix string `yaml:"keyPrefix"`
+}
+
+// Timeouts are the limits the HTTP server puts on each connection, and
+// how long requests in flight get to finish on shutdown.  Zero means no
+// limit, except for Shutdown
+type Timeouts struct {
+	Read     time.Duration `yaml:"read"`
+	Write    time.Duration `yaml:"write"`
+	Idle     time.Duration `yaml:"idle"`
+	Shutdown time.Duration `yaml:"shutdown"`
+}
+
+// The settings for each service that can be called.  name is used in
+// messages and title in -help
+var downstreams = []struct {
+	api, name, title, env string
+	url                   func(c *Config) *string
+}{
+	{VotesAPI, "votes API", "Votes API", "VOTES_API_URL", func(c *Config) *string { return &c.VotesAPIURL }},
+	{VoterAPI, "voter API", "Voter API", "VOTER_API_URL", func(c *Config) *string { return &c.VoterAPIURL }},
+	{PollAPI, "poll API", "Poll API", "POLL_API_URL", func(c *Config) *string { return &c.PollAPIURL }},
+}
+
+// Default returns the configuration svc uses for anything not set
+// elsewhere
+func Default(svc Service) Config {
+	c := Config{
+		Host:       "0.0.0.0",
+		Port:       1080,
+		Store:      StoreRedis,
+		SQLitePath: svc.SQLitePath,
+		Redis:      RedisConfig{Addr: DefaultRedisAddr},
+		Timeouts: Timeouts{
+			Read:     10 * time.Second,
+			Write:    30 * time.Second,
+			Idle:     60 * time.Second,
+			Shutdown: 20 * time.Second,
+		},
+		LogFormat:  logging.FormatJSON,
+		LogLevel:   "info",
+		Traces:     tracing.TracesNone,
+		TracesFile: "traces.json",
+		service:    svc,
+	}
+	for _, d := range downstreams {
+		if u, ok := svc.Calls[d.api]; ok {
+			*d.url(&c) = u
+		}
+	}
+	return c
+}
+
+// Helper to list the environment variable behind each flag.  Where two
+// variables set the same flag the later one wins
+func envVars(svc Service) [][2]string {
+	vars := [][2]string{
+		{"config", "CONFIG_FILE"},
+		{"h", "RLAPI_HOST"},
+		{"p", "RLAPI_PORT"},
+		{"store", "STORE"},
+		{"sqlite", "SQLITE_PATH"},
+	}
+	if svc.RedisEnvAlias != "" {
+		vars = append(vars, [2]string{"redis", svc.RedisEnvAlias})
+	}
+	vars = append(vars, [][2]string{
+		{"redis", "REDIS_URL"},
+		{"redis-password", "REDIS_PASSWORD"},
+		{"redis-db", "REDIS_DB"},
+		{"redis-tls", "REDIS_TLS"},
+		{"redis-key-prefix", "REDIS_KEY_PREFIX"},
+	}...)
+	for _, d := range downstreams {
+		if _, ok := svc.Calls[d.api]; ok {
+			vars = append(vars, [2]string{d.api, d.env})
+		}
+	}
+	return append(vars, [][2]string{
+		{"read-timeout", "READ_TIMEOUT"},
+		{"write-timeout", "WRITE_TIMEOUT"},
+		{"idle-timeout", "IDLE_TIMEOUT"},
+		{"shutdown-timeout", "SHUTDOWN_TIMEOUT"},
+		{"log-format", "LOG_FORMAT"},
+		{"log-level", "LOG_LEVEL"},
+		{"traces", "TRACES_EXPORTER"},
+		{"traces-file", "TRACES_FILE"},
+	}...)
+}
+
+// Helper to define every flag, writing into c.  The defaults shown by
+// -help are whatever c holds when this is called
+func newFlagSet(c *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	fs.StringVar(&c.File, "config", c.File, "YAML file to read the configuration from")
+	fs.BoolVar(&c.PrintConfig, "print-config", c.PrintConfig, "Print the configuration that would be used and exit")
+
+	//0.0.0.0 listens on every network interface, not just localhost, so
+	//other machines and containers can reach the service
+	fs.StringVar(&c.Host, "h", c.Host, "Interface to listen on")
+	fs.UintVar(&c.Port, "p", c.Port, "Port to listen on")
+
+	fs.StringVar(&c.Store, "store", c.Store, fmt.Sprintf("Where %s are kept, redis, memory or sqlite", c.service.Keeps))
+	fs.StringVar(&c.SQLitePath, "sqlite", c.SQLitePath, "Database file for the sqlite store")
+	fs.StringVar(&c.Redis.Addr, "redis", c.Redis.Addr, "Address of redis, host:port")
+	if c.service.RedisFlagAlias != "" {
+		fs.StringVar(&c.Redis.Addr, c.service.RedisFlagAlias, c.Redis.Addr, "Same as -redis, kept for older scripts")
+	}
+	fs.StringVar(&c.Redis.Password, "redis-password", c.Redis.Password, "Password for redis")
+	fs.IntVar(&c.Redis.DB, "redis-db", c.Redis.DB, "Redis database number")
+	fs.BoolVar(&c.Redis.TLS, "redis-tls", c.Redis.TLS, "Connect to redis over TLS")
+	fs.StringVar(&c.Redis.KeyPrefix, "redis-key-prefix", c.Redis.KeyPrefix, "Put in front of every redis key, must match the other services")
+
+	for _, d := range downstreams {
+		if _, ok := c.service.Calls[d.api]; ok {
+			u := d.url(c)
+			fs.StringVar(u, d.api, *u, "Default endpoint for "+d.title)
+		}
+	}
+
+	fs.DurationVar(&c.Timeouts.Read, "read-timeout", c.Timeouts.Read, "Longest time to read a request, including its body")
+	fs.DurationVar(&c.Timeouts.Write, "write-timeout", c.Timeouts.Write, "Longest time to handle a request and write its response")
+	fs.DurationVar(&c.Timeouts.Idle, "idle-timeout", c.Timeouts.Idle, "How long an idle keep-alive connection is kept open")
+	fs.DurationVar(&c.Timeouts.Shutdown, "shutdown-timeout", c.Timeouts.Shutdown, "How long requests in flight get to finish on shutdown")
+
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "Format of log lines, json or text")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "Lowest level logged, debug, info, warn or error")
+	fs.StringVar(&c.Traces, "traces", c.Traces, "Where traces are sent, none, stdout, file or otlp")
+	fs.StringVar(&c.TracesFile, "traces-file", c.TracesFile, "File spans are appended to with -traces=file")
+
+	return fs
+}
+
+// Load builds svc's configuration from the defaults, the config file
+// named by -config or CONFIG_FILE, the environment and args, which should
+// not include the program name.  An error is returned if anything can't be
+// read or the result isn't valid
+func Load(svc Service, args []string) (Config, error) {
+	//The file has to be read before the environment and flags are applied
+	//on top of it, so find out which file to read first.  Mistakes are
+	//ignored here, the second pass reports them
+	first := Config{service: svc}
+	scan := newFlagSet(&first)
+	scan.SetOutput(io.Discard)
+	applyEnv(scan, svc)
+	scan.Parse(args)
+
+	cfg := Default(svc)
+	if first.File != "" {
+		if err := readFile(first.File, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	fs := newFlagSet(&cfg)
+	if err := applyEnv(fs, svc); err != nil {
+		return Config{}, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Helper to set every flag whose environment variable is set
+func applyEnv(fs *flag.FlagSet, svc Service) error {
+	for _, v := range envVars(svc) {
+		value := os.Getenv(v[1])
+		if value == "" {
+			continue
+		}
+		if err := fs.Set(v[0], value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", value, v[1], err)
+		}
+	}
+	return nil
+}
+
+// Helper to read a YAML config file over the top of cfg.  Keys the file
+// leaves out keep their value, keys that aren't settings are an error
+func readFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("reading %s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate checks every setting, and reports all the problems it finds at
+// once
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Port == 0 || c.Port > 65535 {
+		fail("port %d is not between 1 and 65535", c.Port)
+	}
+
+	switch c.Store {
+	case StoreRedis:
+		if _, _, err := net.SplitHostPort(c.Redis.Addr); err != nil {
+			fail("redis address %q is not host:port", c.Redis.Addr)
+		}
+		if c.Redis.DB < 0 {
+			fail("redis database %d can't be negative", c.Redis.DB)
+		}
+		if strings.ContainsAny(c.Redis.KeyPrefix, " \t\r\n") {
+			fail("redis key prefix %q can't contain spaces", c.Redis.KeyPrefix)
+		}
+	case StoreMemory:
+	case StoreSQLite:
+		if c.SQLitePath == "" {
+			fail("sqlite store needs a database file")
+		}
+	default:
+		fail("unknown store %q, use %s, %s or %s", c.Store, StoreRedis, StoreMemory, StoreSQLite)
+	}
+
+	for _, d := range downstreams {
+		u := *d.url(&c)
+		if _, ok := c.service.Calls[d.api]; !ok {
+			if u != "" {
+				fail("%s URL is set, but this service doesn't call the %s", d.name, d.name)
+			}
+			continue
+		}
+		if err := checkURL(u); err != nil {
+			fail("%s URL %q %v", d.name, u, err)
+		}
+	}
+
+	if c.Timeouts.Read < 0 || c.Timeouts.Write < 0 || c.Timeouts.Idle < 0 {
+		fail("timeouts can't be negative")
+	}
+	if c.Timeouts.Shutdown <= 0 {
+		fail("shutdown timeout must be more than zero")
+	}
+
+	if c.LogFormat != logging.FormatJSON && c.LogFormat != logging.FormatText {
+		fail("unknown log format %q, use %s or %s", c.LogFormat, logging.FormatJSON, logging.FormatText)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		fail("unknown log level %q, use debug, info, warn or error", c.LogLevel)
+	}
+
+	switch c.Traces {
+	case tracing.TracesNone, tracing.TracesStdout, tracing.TracesOTLP:
+	case tracing.TracesFile:
+		if c.TracesFile == "" {
+			fail("traces=file needs a traces file")
+		}
+	default:
+		fail("unknown traces exporter %q, use %s, %s, %s or %s", c.Traces,
+			tracing.TracesNone, tracing.TracesStdout, tracing.TracesFile, tracing.TracesOTLP)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Helper to check that a URL points at an HTTP service
+func checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("can't be parsed")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("must start with http:// or https://")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("has no host")
+	}
+	return nil
+}
+
+// Print writes the configuration as YAML, in the same form the config
+// file takes.  The redis password is masked
+func (c Config) Print(w io.Writer) error {
+	if c.Redis.Password != "" {
+		c.Redis.Password = "********"
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A service that calls the votes API, like the voter API does
+var testService = Service{
+	Keeps:      "voters",
+	SQLitePath: "voters.db",
+	Calls:      map[string]string{VotesAPI: "http://localhost:1082"},
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default(testService).Validate(); err != nil {
+		t.Fatalf("default config is not valid: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   string
+	}{
+		{"port zero", func(c *Config) { c.Port = 0 }, "port 0"},
+		{"port too big", func(c *Config) { c.Port = 70000 }, "port 70000"},
+		{"unknown store", func(c *Config) { c.Store = "mongo" }, `unknown store "mongo"`},
+		{"redis address", func(c *Config) { c.Redis.Addr = "localhost" }, "not host:port"},
+		{"redis database", func(c *Config) { c.Redis.DB = -1 }, "can't be negative"},
+		{"redis key prefix", func(c *Config) { c.Redis.KeyPrefix = "a b" }, "can't contain spaces"},
+		{"sqlite path", func(c *Config) { c.Store = StoreSQLite; c.SQLitePath = "" }, "needs a database file"},
+		{"downstream scheme", func(c *Config) { c.VotesAPIURL = "localhost:1082" }, "must start with http"},
+		{"downstream host", func(c *Config) { c.VotesAPIURL = "http://" }, "has no host"},
+		{"uncalled downstream", func(c *Config) { c.PollAPIURL = "http://localhost:1080" }, "doesn't call the poll API"},
+		{"negative timeout", func(c *Config) { c.Timeouts.Read = -time.Second }, "can't be negative"},
+		{"no shutdown timeout", func(c *Config) { c.Timeouts.Shutdown = 0 }, "shutdown timeout"},
+		{"log format", func(c *Config) { c.LogFormat = "xml" }, `unknown log format "xml"`},
+		{"log level", func(c *Config) { c.LogLevel = "loud" }, `unknown log level "loud"`},
+		{"traces exporter", func(c *Config) { c.Traces = "jaeger" }, `unknown traces exporter "jaeger"`},
+		{"traces file", func(c *Config) { c.Traces = "file"; c.TracesFile = "" }, "needs a traces file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default(testService)
+			tt.change(&cfg)
+
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	//Settings that only matter to another store aren't checked
+	cfg := Default(testService)
+	cfg.Store = StoreMemory
+	cfg.Redis.Addr = ""
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("memory store with no redis address: %v", err)
+	}
+
+	//Every problem is reported at once
+	cfg = Default(testService)
+	cfg.Port = 0
+	cfg.LogLevel = "loud"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "port") || !strings.Contains(err.Error(), "log level") {
+		t.Errorf("Validate() = %v, want both problems", err)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := "port: 2000\nstore: memory\nlogLevel: debug\nredis:\n  keyPrefix: file-\n"
+	if err := os.WriteFile(file, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("RLAPI_PORT", "3000")
+	t.Setenv("REDIS_KEY_PREFIX", "env-")
+	cfg, err := Load(testService, []string{"-p", "4000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Port != 4000 {
+		t.Errorf("port = %d, want the flag's 4000", cfg.Port)
+	}
+	if cfg.Redis.KeyPrefix != "env-" {
+		t.Errorf("key prefix = %q, want the environment's env-", cfg.Redis.KeyPrefix)
+	}
+	if cfg.Store != StoreMemory || cfg.LogLevel != "debug" {
+		t.Errorf("store %q and log level %q, want the file's memory and debug", cfg.Store, cfg.LogLevel)
+	}
+	if cfg.VotesAPIURL != "http://localhost:1082" {
+		t.Errorf("votes API URL = %q, want the service default", cfg.VotesAPIURL)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("colour: blue\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"unknown key in file", []string{"-config", file}, "colour"},
+		{"missing file", []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, "missing.yaml"},
+		{"flag for an uncalled service", []string{"-pollapi", "http://localhost:1080"}, "pollapi"},
+		{"extra arguments", []string{"serve"}, "unexpected arguments"},
+		{"invalid result", []string{"-p", "0"}, "port 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(testService, tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load(%v) = %v, want an error containing %q", tt.args, err, tt.want)
+			}
+		})
+	}
+
+	t.Setenv("RLAPI_PORT", "eighty")
+	if _, err := Load(testService, nil); err == nil || !strings.Contains(err.Error(), "RLAPI_PORT") {
+		t.Errorf("bad RLAPI_PORT gave %v, want an error naming it", err)
+	}
+}
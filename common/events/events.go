@@ -0,0 +1,309 @@
+// Package events reads and writes the domain event stream.  All three
+// services publish to the same stream on the shared redis instance, so a
+// reader sees every domain event in one place
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// StreamKey is the key of the stream, before any key prefix
+	StreamKey = "domain-events"
+
+	// The stream is trimmed to roughly this many entries so it can't
+	// grow without bound
+	MaxLen = 10000
+
+	// Maximum number of events returned by one Read call
+	ReadCount = 100
+
+	// Settings for consumer group subscribers
+	subscribeBatchSize     = 10
+	subscribeBlockTime     = 5 * time.Second
+	subscribeRetryDelay    = time.Second
+	subscribeClaimIdleTime = time.Minute
+	subscribeMaxDeliveries = 5
+)
+
+// The domain events published by the poll, voter and votes services
+const (
+	PollCreated  = "PollCreated"
+	PollUpdated  = "PollUpdated"
+	PollDeleted  = "PollDeleted"
+	VoterCreated = "VoterCreated"
+	VoterUpdated = "VoterUpdated"
+	VoterDeleted = "VoterDeleted"
+	VoteCast     = "VoteCast"
+	VoteDeleted  = "VoteDeleted"
+)
+
+// Event is a single entry on the domain event stream.  ID is the stream
+// entry id assigned by redis, EntityID is the id of the poll, voter or
+// vote the event is about and Data holds its JSON, if any
+type Event struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	EntityID   uint            `json:"entityId"`
+	Source     string          `json:"source"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Data       json.RawMessage `json:"data,omitempty"`
+}
+
+// Handler processes a single event.  Returning an error leaves the event
+// unacknowledged so it will be delivered again
+type Handler func(Event) error
+
+// Publish appends an event to the stream at key.  source names the
+// service publishing it and data, if not nil, is stored as JSON
+func Publish(ctx context.Context, client redis.Cmdable, key string, source string,
+	eventType string, entityId uint, data interface{}) error {
+
+	payload := ""
+	if data != nil {
+		dataJSON, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		payload = string(dataJSON)
+	}
+
+	return client.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"type":       eventType,
+			"entityId":   entityId,
+			"source":     source,
+			"occurredAt": time.Now().UTC().Format(time.RFC3339Nano),
+			"data":       payload,
+		},
+	}).Err()
+}
+
+// Latest returns the id of the newest entry on the stream at key, or
+// "0-0" if the stream is empty.  It is the starting point for Read when
+// only future events are wanted
+func Latest(ctx context.Context, client redis.Cmdable, key string) (string, error) {
+	msgs, err := client.XRevRangeN(ctx, key, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(msgs) == 0 {
+		return "0-0", nil
+	}
+	return msgs[0].ID, nil
+}
+
+// Read waits up to block for events published on the stream at key after
+// lastID.  Every reader sees every event.  It returns the events along
+// with the id to pass to the next call
+func Read(ctx context.Context, client redis.Cmdable, key string, lastID string,
+	block time.Duration) ([]Event, string, error) {
+
+	streams, err := client.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{key, lastID},
+		Count:   ReadCount,
+		Block:   block,
+	}).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, lastID, nil
+		}
+		return nil, lastID, err
+	}
+
+	var read []Event
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			lastID = msg.ID
+			event, err := fromMessage(msg)
+			if err != nil {
+				slog.WarnContext(ctx, "Skipping malformed event", "id", msg.ID, "error", err)
+				continue
+			}
+			read = append(read, event)
+		}
+	}
+
+	return read, lastID, nil
+}
+
+// Subscribe reads the stream at key as consumer within the consumer
+// group, calling handler once per event.  The group is created if needed
+// and starts with events published after that point.  Unlike Read, each
+// event goes to only one consumer in the group, and it is only
+// acknowledged once handler returns nil, so it is redelivered if the
+// handler fails or the consumer dies before finishing it; events left
+// pending by another consumer for too long are claimed by this one.  An
+// event that keeps failing is dropped after a few attempts so it can't
+// stall the group.  Subscribe blocks until ctx is cancelled
+func Subscribe(ctx context.Context, client redis.Cmdable, key string, group string,
+	consumer string, handler Handler) error {
+
+	err := client.XGroupCreateMkStream(ctx, key, group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	//Reading from "0" returns the events delivered to this consumer but
+	//never acknowledged, reading from ">" returns new events.  We always
+	//drain the pending events first
+	readFrom := "0"
+	attempts := make(map[string]int)
+
+	for ctx.Err() == nil {
+		if claimed, err := claimStale(ctx, client, key, group, consumer); err != nil {
+			slog.ErrorContext(ctx, "Error claiming stale events", "group", group, "error", err)
+		} else if claimed > 0 {
+			readFrom = "0"
+		}
+
+		streams, err := client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: consumer,
+			Streams:  []string{key, readFrom},
+			Count:    subscribeBatchSize,
+			Block:    subscribeBlockTime,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			slog.ErrorContext(ctx, "Error reading events", "group", group, "error", err)
+			time.Sleep(subscribeRetryDelay)
+			continue
+		}
+
+		numRead := 0
+		failed := false
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				numRead++
+				if !handleMessage(ctx, client, key, group, msg, handler, attempts) {
+					failed = true
+				}
+			}
+		}
+
+		switch {
+		case failed:
+			//Give whatever the handler depends on a moment to recover,
+			//then retry from the pending events
+			readFrom = "0"
+			time.Sleep(subscribeRetryDelay)
+		case readFrom == "0" && numRead == 0:
+			readFrom = ">"
+		}
+	}
+
+	return nil
+}
+
+// Helper to run the handler for one stream entry and acknowledge it.
+// Returns false if the entry should be retried
+func handleMessage(ctx context.Context, client redis.Cmdable, key string, group string,
+	msg redis.XMessage, handler Handler, attempts map[string]int) bool {
+
+	event, err := fromMessage(msg)
+	if err != nil {
+		//A malformed entry will never succeed, so don't retry it
+		slog.WarnContext(ctx, "Skipping malformed event", "id", msg.ID, "error", err)
+	} else if err := handler(event); err != nil {
+		attempts[msg.ID]++
+		if attempts[msg.ID] < subscribeMaxDeliveries {
+			slog.WarnContext(ctx, "Error handling event", "id", msg.ID, "error", err)
+			return false
+		}
+		slog.ErrorContext(ctx, "Dropping event after repeated failures", "id", msg.ID, "error", err)
+	}
+
+	delete(attempts, msg.ID)
+	if err := client.XAck(ctx, key, group, msg.ID).Err(); err != nil {
+		slog.ErrorContext(ctx, "Error acknowledging event", "id", msg.ID, "error", err)
+		return false
+	}
+
+	return true
+}
+
+// Helper to take over events that another consumer in the group read but
+// has not acknowledged for a while, for example because it crashed.
+// Returns how many events were claimed
+func claimStale(ctx context.Context, client redis.Cmdable, key string, group string,
+	consumer string) (int, error) {
+
+	pending, err := client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: key,
+		Group:  group,
+		Idle:   subscribeClaimIdleTime,
+		Start:  "-",
+		End:    "+",
+		Count:  subscribeBatchSize,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []string
+	for _, entry := range pending {
+		if entry.Consumer != consumer {
+			ids = append(ids, entry.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	claimed, err := client.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   key,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  subscribeClaimIdleTime,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(claimed), nil
+}
+
+// Helper to turn a stream entry back into an Event
+func fromMessage(msg redis.XMessage) (Event, error) {
+	field := func(name string) string {
+		value, _ := msg.Values[name].(string)
+		return value
+	}
+
+	entityId, err := strconv.ParseUint(field("entityId"), 10, 32)
+	if err != nil {
+		return Event{}, err
+	}
+	occurredAt, err := time.Parse(time.RFC3339Nano, field("occurredAt"))
+	if err != nil {
+		return Event{}, err
+	}
+
+	event := Event{
+		ID:         msg.ID,
+		Type:       field("type"),
+		EntityID:   uint(entityId),
+		Source:     field("source"),
+		OccurredAt: occurredAt,
+	}
+	if data := field("data"); data != "" {
+		event.Data = json.RawMessage(data)
+	}
+
+	return event, nil
+}
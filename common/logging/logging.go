@@ -0,0 +1,84 @@
+// Package logging sets up the structured logger every log line in the
+// service goes through, and carries the id of the request a line was
+// written for so it can be added to the line
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The formats Setup can write log lines in
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Key the request id is kept under in a context
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the id of the request
+// being handled
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id carried by ctx, or "" if there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Setup makes the default slog logger write to stdout in format, one of
+// FormatJSON or FormatText, dropping anything below level (debug, info,
+// warn or error).  Lines logged with a context get the request id and
+// trace id it carries.  The standard log package goes through the same
+// logger afterwards
+func Setup(format string, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q, use debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch format {
+	case FormatJSON:
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	case FormatText:
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	default:
+		return fmt.Errorf("unknown log format %q, use %s or %s", format, FormatJSON, FormatText)
+	}
+
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// contextHandler adds the request id and trace id in a line's context to
+// the line
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("requestId", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("traceId", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,55 @@
+// Package storage holds what the poll, voter and votes stores share: the
+// kinds of error they report, the redis id counters and paging through
+// the sorted id indexes
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// The kinds of error a store can report.  Every specific error, like
+// ErrPollNotFound or ErrAlreadyVoted, wraps one of these so callers can
+// decide how to respond with errors.Is without knowing every error
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrConflict      = errors.New("conflict")
+	ErrInvalid       = errors.New("invalid")
+	ErrCorruptRecord = errors.New("corrupt record")
+)
+
+// domainError is a specific error that belongs to one of the kinds above.
+// Its message is its own, the kind only shows through errors.Is
+type domainError struct {
+	kind error
+	msg  string
+}
+
+func (e *domainError) Error() string {
+	return e.msg
+}
+
+func (e *domainError) Unwrap() error {
+	return e.kind
+}
+
+// NewError declares a specific error of the given kind
+func NewError(kind error, msg string) error {
+	return &domainError{kind: kind, msg: msg}
+}
+
+// CorruptRecord reports a stored record that can't be read back
+func CorruptRecord(key string, err error) error {
+	return &domainError{kind: ErrCorruptRecord, msg: fmt.Sprintf("%s could not be read: %v", key, err)}
+}
+
+// FromRedis translates an error from redis, a missing key becomes notFound
+// and anything else is returned as it is
+func FromRedis(err error, notFound error) error {
+	if err != nil && (errors.Is(err, redis.Nil) || err.Error() == redis.Nil.Error()) {
+		return notFound
+	}
+	return err
+}
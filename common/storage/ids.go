@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// How many ids a create tries before giving up, in case an id it
+// allocated was already taken by a client that picked its own
+const MaxIdAttempts = 5
+
+// raiseIdSeqScript moves an id counter up to ARGV[1] if it is lower, so
+// ids chosen by clients are never handed out again by the server
+//
+// KEYS[1] is the id counter, ARGV[1] is an id that is in use
+var raiseIdSeqScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if current < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// NextId allocates the next id from the counter at key
+func NextId(ctx context.Context, client redis.Cmdable, key string) (uint, error) {
+	id, err := client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
+
+// RaiseIdSeq makes sure the counter at key is at least id
+func RaiseIdSeq(ctx context.Context, client redis.Cmdable, key string, id uint) error {
+	return raiseIdSeqScript.Run(ctx, client, []string{key}, id).Err()
+}
+
+// RebuildIdSeq raises the counter at key past the id of every key starting
+// with prefix, so data written before the counter existed is never
+// overwritten
+func RebuildIdSeq(ctx context.Context, client redis.Cmdable, key string, prefix string) error {
+	ks, err := ScanKeys(ctx, client, prefix+"*")
+	if err != nil {
+		return err
+	}
+
+	var highest uint
+	for _, k := range ks {
+		if id, err := IdFromKey(k, prefix); err == nil && id > highest {
+			highest = id
+		}
+	}
+
+	return RaiseIdSeq(ctx, client, key, highest)
+}
+
+// IdFromKey recovers the numeric id from a key such as poll:3
+func IdFromKey(key string, prefix string) (uint, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(key, prefix), 10, 32)
+	return uint(id), err
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/nitishm/go-rejson/v4"
+)
+
+// How many keys each SCAN call is asked to look at
+const ScanBatchSize = 1000
+
+// How many documents each JSON.MGET asks for
+const mgetBatchSize = 500
+
+// ScanKeys collects every key matching pattern.  Unlike KEYS, SCAN walks
+// the keyspace in small steps so it never blocks redis for long
+func ScanKeys(ctx context.Context, client redis.Cmdable, pattern string) ([]string, error) {
+	seen := make(map[string]bool)
+	var keys []string
+
+	iter := client.Scan(ctx, 0, pattern, ScanBatchSize).Iterator()
+	for iter.Next(ctx) {
+		//SCAN can return the same key more than once
+		if key := iter.Val(); !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, iter.Err()
+}
+
+// IndexId adds an id to the sorted set key, scored by the id itself so
+// the ids can be listed in order a page at a time
+func IndexId(ctx context.Context, client redis.Cmdable, key string, id uint) error {
+	return client.ZAdd(ctx, key, &redis.Z{Score: float64(id), Member: id}).Err()
+}
+
+// UnindexId removes an id from the sorted set key
+func UnindexId(ctx context.Context, client redis.Cmdable, key string, id uint) error {
+	return client.ZRem(ctx, key, id).Err()
+}
+
+// RebuildIndex adds the id of every key starting with prefix, such as
+// poll:3, to the sorted set key, so data written before the index existed
+// can still be listed
+func RebuildIndex(ctx context.Context, client redis.Cmdable, key string, prefix string) error {
+	ks, err := ScanKeys(ctx, client, prefix+"*")
+	if err != nil {
+		return err
+	}
+
+	members := make([]*redis.Z, 0, len(ks))
+	for _, k := range ks {
+		if id, err := IdFromKey(k, prefix); err == nil {
+			members = append(members, &redis.Z{Score: float64(id), Member: id})
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+
+	return client.ZAdd(ctx, key, members...).Err()
+}
+
+// SetIds reads every id in the sorted set key in ascending order
+func SetIds(ctx context.Context, client redis.Cmdable, key string) ([]uint, error) {
+	members, err := client.ZRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return idsFromMembers(members), nil
+}
+
+// RangeIds reads up to limit ids from the sorted set key that come after
+// cursor.  An empty cursor starts at the beginning, and the returned
+// cursor is empty once there is nothing left to read
+func RangeIds(ctx context.Context, client redis.Cmdable, key string, cursor string, limit int) ([]uint, string, error) {
+	limit = PageLimit(limit)
+
+	//The cursor is the last id of the previous page, so the next page
+	//starts just after it
+	min := "-inf"
+	if cursor != "" {
+		after, err := ParseCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		min = "(" + strconv.FormatUint(uint64(after), 10)
+	}
+
+	//Ask for one extra id to find out if there is another page
+	members, err := client.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   min,
+		Max:   "+inf",
+		Count: int64(limit + 1),
+	}).Result()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return TrimPage(idsFromMembers(members), limit)
+}
+
+// Document is the stored JSON for one record along with the key it was
+// read from, so a record that can't be decoded can be named
+type Document struct {
+	Key  string
+	JSON []byte
+}
+
+// GetDocuments reads the stored JSON for a list of ids, each kept under
+// prefix followed by the id.  JSON.MGET is sent a batch of keys at a time
+// instead of making one round trip per id, and ids whose key no longer
+// exists are left out
+func GetDocuments(jsonHelper *rejson.Handler, prefix string, ids []uint) ([]Document, error) {
+	docs := make([]Document, 0, len(ids))
+
+	for start := 0; start < len(ids); start += mgetBatchSize {
+		end := start + mgetBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		keys := make([]string, 0, end-start)
+		for _, id := range ids[start:end] {
+			keys = append(keys, fmt.Sprintf("%s%d", prefix, id))
+		}
+
+		res, err := jsonHelper.JSONMGet(".", keys...)
+		if err != nil {
+			return nil, err
+		}
+		for i, doc := range res.([]interface{}) {
+			if b, ok := doc.([]byte); ok {
+				docs = append(docs, Document{Key: keys[i], JSON: b})
+			}
+		}
+	}
+
+	return docs, nil
+}
+
+// Helper to turn sorted set members back into ids
+func idsFromMembers(members []string) []uint {
+	ids := make([]uint, 0, len(members))
+	for _, member := range members {
+		if id, err := strconv.ParseUint(member, 10, 32); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
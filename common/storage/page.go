@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"sort"
+	"strconv"
+)
+
+// Page sizes used when listing with a cursor
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 1000
+)
+
+var ErrInvalidCursor = NewError(ErrInvalid, "cursor is not valid")
+
+// PageOfIds pages through ids that are already sorted in ascending order,
+// the same way RangeIds pages through an index.  An empty cursor starts at
+// the beginning, and the returned cursor is empty once there is nothing
+// left to read
+func PageOfIds(ids []uint, cursor string, limit int) ([]uint, string, error) {
+	limit = PageLimit(limit)
+
+	start := 0
+	if cursor != "" {
+		after, err := ParseCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		start = sort.Search(len(ids), func(i int) bool { return ids[i] > after })
+	}
+
+	end := start + limit + 1
+	if end > len(ids) {
+		end = len(ids)
+	}
+	return TrimPage(ids[start:end], limit)
+}
+
+// PageLimit keeps a page limit within bounds
+func PageLimit(limit int) int {
+	if limit <= 0 || limit > MaxPageLimit {
+		return DefaultPageLimit
+	}
+	return limit
+}
+
+// ParseCursor reads a cursor, which is the last id of the previous page
+func ParseCursor(cursor string) (uint, error) {
+	after, err := strconv.ParseUint(cursor, 10, 32)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return uint(after), nil
+}
+
+// TrimPage cuts a page down to limit ids.  ids holds one id more than the
+// page when there is another page after it, in which case the cursor for
+// that page is returned
+func TrimPage(ids []uint, limit int) ([]uint, string, error) {
+	if len(ids) <= limit {
+		return ids, "", nil
+	}
+	ids = ids[:limit]
+	return ids, strconv.FormatUint(uint64(ids[limit-1]), 10), nil
+}
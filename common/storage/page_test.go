@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPageOfIds(t *testing.T) {
+	ids := []uint{1, 2, 3, 5, 8, 13}
+
+	tests := []struct {
+		name   string
+		cursor string
+		limit  int
+		want   []uint
+		next   string
+	}{
+		{"first page", "", 2, []uint{1, 2}, "2"},
+		{"middle page", "2", 2, []uint{3, 5}, "5"},
+		{"last page", "5", 2, []uint{8, 13}, ""},
+		{"cursor between ids", "4", 3, []uint{5, 8, 13}, ""},
+		{"cursor past the end", "13", 2, []uint{}, ""},
+		{"limit covers everything", "", 6, ids, ""},
+		{"default limit", "", 0, ids, ""},
+		{"limit over the maximum", "", MaxPageLimit + 1, ids, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next, err := PageOfIds(ids, tt.cursor, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || next != tt.next {
+				t.Errorf("got %v with cursor %q, want %v with cursor %q", got, next, tt.want, tt.next)
+			}
+		})
+	}
+
+	for _, cursor := range []string{"abc", "-1", "1.5"} {
+		if _, _, err := PageOfIds(ids, cursor, 2); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor %q gave %v, want %v", cursor, err, ErrInvalidCursor)
+		}
+	}
+}
+
+func TestTrimPage(t *testing.T) {
+	tests := []struct {
+		ids   []uint
+		limit int
+		want  []uint
+		next  string
+	}{
+		{nil, 2, nil, ""},
+		{[]uint{4, 7}, 2, []uint{4, 7}, ""},
+		{[]uint{4, 7, 9}, 2, []uint{4, 7}, "7"},
+	}
+
+	for _, tt := range tests {
+		got, next, err := TrimPage(tt.ids, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) || next != tt.next {
+			t.Errorf("TrimPage(%v, %d) = %v, %q, want %v, %q", tt.ids, tt.limit, got, next, tt.want, tt.next)
+		}
+	}
+}
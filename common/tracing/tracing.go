@@ -0,0 +1,74 @@
+// Package tracing installs the OpenTelemetry tracer provider the services
+// send their spans through
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// The places Setup can send spans
+const (
+	TracesNone   = "none"
+	TracesStdout = "stdout"
+	TracesFile   = "file"
+	TracesOTLP   = "otlp"
+)
+
+// Setup installs the tracer provider every span in the service goes
+// through, naming the service service.  exporter is one of TracesNone,
+// TracesStdout, TracesFile or TracesOTLP, file is only used by TracesFile.
+// TracesOTLP sends spans over HTTP to the collector named by the standard
+// OTEL_EXPORTER_OTLP_* variables.  The returned function flushes any spans
+// not yet exported
+func Setup(service string, exporter string, file string) (func(context.Context) error, error) {
+	//Trace context is passed on even when spans aren't recorded, so the
+	//other services can still join a trace started upstream
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var opt sdktrace.TracerProviderOption
+	switch exporter {
+	case TracesNone:
+		return func(context.Context) error { return nil }, nil
+	case TracesStdout, TracesFile:
+		out := os.Stdout
+		if exporter == TracesFile {
+			f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, err
+			}
+			out = f
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(out))
+		if err != nil {
+			return nil, err
+		}
+		//Local runs want to see spans straight away
+		opt = sdktrace.WithSyncer(exp)
+	case TracesOTLP:
+		exp, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		opt = sdktrace.WithBatcher(exp)
+	default:
+		return nil, fmt.Errorf("unknown traces exporter %q, use %s, %s, %s or %s",
+			exporter, TracesNone, TracesStdout, TracesFile, TracesOTLP)
+	}
+
+	provider := sdktrace.NewTracerProvider(opt,
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
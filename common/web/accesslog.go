@@ -0,0 +1,32 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessLog is middleware that logs every request once it has been
+// handled, in place of gin's own request logger.  It has to come after
+// RequestID so the line carries the request id
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(c.Request.Context(), level, "Request handled",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"durationMs", float64(time.Since(start).Microseconds())/1000,
+			"clientIp", c.ClientIP(),
+		)
+	}
+}
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"drexel.edu/common/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorResponse is the body of every error an API returns.  Code is a
+// short machine readable name for the kind of error and RequestID matches
+// the X-Request-ID header, so the failure can be found in the logs
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId"`
+}
+
+// The codes used in error responses
+const (
+	CodeInvalidRequest = "invalid_request"
+	CodeInvalidEntity  = "invalid_entity"
+	CodeNotFound       = "not_found"
+	CodeConflict       = "conflict"
+	CodeCorruptRecord  = "corrupt_record"
+	CodeUpstreamError  = "upstream_error"
+	CodeUnavailable    = "unavailable"
+	CodeInternalError  = "internal_error"
+)
+
+// CodeForStatus picks the code for a status when the error doesn't say more
+func CodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnprocessableEntity:
+		return CodeInvalidEntity
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusBadGateway:
+		return CodeUpstreamError
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	return CodeInternalError
+}
+
+// AbortWithError sends an error response and stops the request
+func AbortWithError(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, ErrorResponse{
+		Code:      CodeForStatus(status),
+		Message:   message,
+		RequestID: requestID(c),
+	})
+}
+
+// AbortWithStoreError sends the response for an error from a store, based
+// on the storage error kind it wraps.  Unexpected errors only say that something
+// went wrong, the details are left to the log
+func AbortWithStoreError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		AbortWithError(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, storage.ErrConflict):
+		AbortWithError(c, http.StatusConflict, err.Error())
+	case errors.Is(err, storage.ErrInvalid):
+		AbortWithError(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, storage.ErrCorruptRecord):
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{
+			Code:      CodeCorruptRecord,
+			Message:   "A stored record could not be read",
+			RequestID: requestID(c),
+		})
+	default:
+		AbortWithError(c, http.StatusInternalServerError, "Internal error")
+	}
+}
@@ -0,0 +1,41 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"drexel.edu/common/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// PageResponse is the body returned by the list endpoints when a page is
+// asked for.  The nextCursor field is left out on the last page
+type PageResponse struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// ParsePage reads the ?limit= and ?cursor= query parameters.  paged is
+// false when neither is given, in which case the endpoint still returns
+// the plain list it always has.  ok is false if a 400 has already been
+// sent
+func ParsePage(c *gin.Context) (cursor string, limit int, paged bool, ok bool) {
+	limitS, hasLimit := c.GetQuery("limit")
+	cursor, hasCursor := c.GetQuery("cursor")
+	if !hasLimit && !hasCursor {
+		return "", 0, false, true
+	}
+
+	limit = storage.DefaultPageLimit
+	if hasLimit {
+		var err error
+		limit, err = strconv.Atoi(limitS)
+		if err != nil || limit < 1 || limit > storage.MaxPageLimit {
+			AbortWithError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", storage.MaxPageLimit))
+			return "", 0, true, false
+		}
+	}
+
+	return cursor, limit, true, true
+}
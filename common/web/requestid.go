@@ -0,0 +1,72 @@
+// Package web holds the gin middleware and helpers every service's API
+// shares, so requests are logged, ids are passed on and errors and pages
+// come back in the same shape whichever service answers
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"drexel.edu/common/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the id of a request.  A caller can choose the id
+// by sending it, otherwise one is made up
+const RequestIDHeader = "X-Request-ID"
+
+// Key the request id is kept under in the gin context
+const requestIDKey = "requestId"
+
+// Ids sent by callers are only used if they look like this, so they are
+// safe to log and echo back
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID is middleware that gives every request an id.  The id is sent
+// back in the X-Request-ID header and in the body of error responses,
+// added to every line logged for the request, and recorded on the
+// request's span so a trace can be found from it
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		c.Set(requestIDKey, id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Header(RequestIDHeader, id)
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("request.id", id))
+		c.Next()
+	}
+}
+
+// Helper to read the id RequestID gave the request
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// Helper to make up a random request id
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// ForwardRequestID passes the request id on with the calls a resty client
+// makes, so the other services log them under the same id
+func ForwardRequestID(client *resty.Client) *resty.Client {
+	client.OnBeforeRequest(func(_ *resty.Client, req *resty.Request) error {
+		if id := logging.RequestID(req.Context()); id != "" {
+			req.SetHeader(RequestIDHeader, id)
+		}
+		return nil
+	})
+	return client
+}
@@ -0,0 +1,89 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"drexel.edu/common/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Helper to build a router with RequestID in front of a handler that
+// fails with err
+func newTestRouter(err error) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET("/", func(c *gin.Context) {
+		AbortWithStoreError(c, err)
+	})
+	return r
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		sent string
+		kept bool
+	}{
+		{"caller chosen id", "abc-123", true},
+		{"no id", "", false},
+		{"id with a space", "abc 123", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.sent != "" {
+				req.Header.Set(RequestIDHeader, tt.sent)
+			}
+			w := httptest.NewRecorder()
+			newTestRouter(storage.ErrNotFound).ServeHTTP(w, req)
+
+			id := w.Header().Get(RequestIDHeader)
+			if id == "" || (id == tt.sent) != tt.kept {
+				t.Fatalf("got request id %q for %q", id, tt.sent)
+			}
+			var resp ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.RequestID != id {
+				t.Errorf("got requestId %q in the body, want %q", resp.RequestID, id)
+			}
+		})
+	}
+}
+
+func TestAbortWithStoreError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"not found", storage.NewError(storage.ErrNotFound, "poll 1 not found"), http.StatusNotFound, CodeNotFound},
+		{"conflict", storage.ErrConflict, http.StatusConflict, CodeConflict},
+		{"invalid", storage.ErrInvalid, http.StatusBadRequest, CodeInvalidRequest},
+		{"corrupt record", storage.CorruptRecord("poll:1", fmt.Errorf("bad json")), http.StatusInternalServerError, CodeCorruptRecord},
+		{"unexpected", fmt.Errorf("connection refused"), http.StatusInternalServerError, CodeInternalError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			newTestRouter(tt.err).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			var resp ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.status || resp.Code != tt.code {
+				t.Errorf("got %d %q, want %d %q", w.Code, resp.Code, tt.status, tt.code)
+			}
+		})
+	}
+}
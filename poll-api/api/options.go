@@ -0,0 +1,204 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"drexel.edu/common/web"
+	"drexel.edu/poll-api/db"
+	"github.com/gin-gonic/gin"
+)
+
+// Body for adding or renaming an option
+type optionText struct {
+	PollOptionText string `json:"pollOptionText"`
+}
+
+// Body for reordering the options of a poll
+type optionOrder struct {
+	OptionIDs []uint `json:"optionIds"`
+}
+
+// implementation for GET /polls/:id/options
+// returns the options of a poll, retired ones included
+func (p *PollAPI) GetPollOptions(c *gin.Context) {
+	pollId, ok := parsePollId(c)
+	if !ok {
+		return
+	}
+
+	poll, err := p.store(c).GetSinglePollResource(pollId)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Item not found", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	options := poll.PollOptions
+	if options == nil {
+		options = make([]db.PollOption, 0)
+	}
+	c.JSON(http.StatusOK, options)
+}
+
+// implementation for POST /polls/:id/options
+// adds an option to a poll, the option id is allocated by the server
+func (p *PollAPI) AddPollOption(c *gin.Context) {
+	pollId, ok := parsePollId(c)
+	if !ok {
+		return
+	}
+
+	var body optionText
+	if err := c.ShouldBindJSON(&body); err != nil {
+		slog.WarnContext(c.Request.Context(), "Error binding JSON", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	option, err := p.store(c).AddPollOption(pollId, body.PollOptionText)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error adding option", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/polls/%d/options/%d", pollId, option.PollOptionID))
+	c.JSON(http.StatusCreated, option)
+}
+
+// implementation for PATCH /polls/:id/options/:optionid
+// renames an option.  Options that already have votes can't be renamed
+// unless ?force=true is given
+func (p *PollAPI) RenamePollOption(c *gin.Context) {
+	pollId, optionId, ok := parseOptionIds(c)
+	if !ok {
+		return
+	}
+
+	var body optionText
+	if err := c.ShouldBindJSON(&body); err != nil {
+		slog.WarnContext(c.Request.Context(), "Error binding JSON", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	if c.Query("force") != "true" {
+		existing, err := p.store(c).GetSinglePollResource(pollId)
+		if err != nil {
+			slog.WarnContext(c.Request.Context(), "Item not found", "error", err)
+			web.AbortWithStoreError(c, err)
+			return
+		}
+
+		//Check the rename the same way a full poll update is checked
+		updated := existing
+		updated.PollOptions = make([]db.PollOption, len(existing.PollOptions))
+		copy(updated.PollOptions, existing.PollOptions)
+		for i := range updated.PollOptions {
+			if updated.PollOptions[i].PollOptionID == optionId {
+				updated.PollOptions[i].PollOptionText = body.PollOptionText
+			}
+		}
+
+		if status, err := p.checkVotedOptions(c.Request.Context(), existing, updated); err != nil {
+			slog.WarnContext(c.Request.Context(), "Rejecting option rename", "error", err)
+			web.AbortWithError(c, status, err.Error())
+			return
+		}
+	}
+
+	option, err := p.store(c).RenamePollOption(pollId, optionId, body.PollOptionText)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error renaming option", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, option)
+}
+
+// implementation for DELETE /polls/:id/options/:optionid
+// retires an option.  It stays on the poll so its votes still count, but
+// accepts no new votes
+func (p *PollAPI) RetirePollOption(c *gin.Context) {
+	pollId, optionId, ok := parseOptionIds(c)
+	if !ok {
+		return
+	}
+
+	option, err := p.store(c).RetirePollOption(pollId, optionId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error retiring option", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, option)
+}
+
+// implementation for PUT /polls/:id/options/order
+// reorders the options of a poll
+func (p *PollAPI) ReorderPollOptions(c *gin.Context) {
+	pollId, ok := parsePollId(c)
+	if !ok {
+		return
+	}
+
+	var body optionOrder
+	if err := c.ShouldBindJSON(&body); err != nil {
+		slog.WarnContext(c.Request.Context(), "Error binding JSON", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	options, err := p.store(c).ReorderPollOptions(pollId, body.OptionIDs)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error reordering options", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+// Helper to read the :id parameter.  If it returns false a response has
+// already been written
+func parsePollId(c *gin.Context) (uint, bool) {
+	idS := c.Param("id")
+	if idS == "" {
+		web.AbortWithError(c, http.StatusBadRequest, "No poll ID provided")
+		return 0, false
+	}
+	id64, err := strconv.ParseInt(idS, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to int64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid poll ID")
+		return 0, false
+	}
+	return uint(id64), true
+}
+
+// Helper to read the :id and :optionid parameters.  If it returns false a
+// response has already been written
+func parseOptionIds(c *gin.Context) (uint, uint, bool) {
+	pollId, ok := parsePollId(c)
+	if !ok {
+		return 0, 0, false
+	}
+
+	idO := c.Param("optionid")
+	if idO == "" {
+		web.AbortWithError(c, http.StatusBadRequest, "No option ID provided")
+		return 0, 0, false
+	}
+	id64, err := strconv.ParseInt(idO, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting optionid to int64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid option ID")
+		return 0, 0, false
+	}
+	return pollId, uint(id64), true
+}
@@ -1,27 +1,56 @@
 package api
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"time"
 
+	"drexel.edu/common/storage"
+	"drexel.edu/common/web"
 	"drexel.edu/poll-api/db"
 	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
 )
 
+// Calls to the votes and voter APIs give up after this long, so a stuck
+// service can't hold up a request or a scheduled close indefinitely
+const apiClientTimeout = 5 * time.Second
+
 // The api package creates and maintains a reference to the data handler
 // this is a good design practice
 type PollAPI struct {
-	db *db.PollList
+	db          db.PollStore
+	votesAPIURL string
+	voterAPIURL string
+	apiClient   *resty.Client
+	storeKind   string
+	health      *healthStats
 }
 
-func New() (*PollAPI, error) {
-	dbHandler, err := db.NewPollList()
+func New(store string, redisCfg db.RedisConfig, sqlitePath string, votesAPIURL string, voterAPIURL string) (*PollAPI, error) {
+	dbHandler, err := db.NewStore(store, redisCfg, sqlitePath)
 	if err != nil {
 		return nil, err
 	}
 
-	return &PollAPI{db: dbHandler}, nil
+	return &PollAPI{
+		db:          dbHandler,
+		votesAPIURL: votesAPIURL,
+		voterAPIURL: voterAPIURL,
+		apiClient:   instrumentClient(traceClient(web.ForwardRequestID(resty.New().SetTimeout(apiClientTimeout)))),
+		storeKind:   store,
+		health:      newHealthStats(),
+	}, nil
+}
+
+// Close releases the store.  Call it once the server has stopped handling
+// requests
+func (p *PollAPI) Close() error {
+	return p.db.Close()
 }
 
 //Below we implement the API functions.  Some of the framework
@@ -38,11 +67,26 @@ func New() (*PollAPI, error) {
 // implementation for GET /todo
 // returns all todos
 func (p *PollAPI) GetAllPollResources(c *gin.Context) {
+	cursor, limit, paged, ok := web.ParsePage(c)
+	if !ok {
+		return
+	}
 
-	pollList, err := p.db.GetAllPolls()
+	if paged {
+		pollList, next, err := p.store(c).GetPollsPage(cursor, limit)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error Getting Polls Page", "error", err)
+			web.AbortWithStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, web.PageResponse{Items: pollList, NextCursor: next})
+		return
+	}
+
+	pollList, err := p.store(c).GetAllPolls()
 	if err != nil {
-		log.Println("Error Getting All Voters: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
+		slog.ErrorContext(c.Request.Context(), "Error Getting All Voters", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
 	//Note that the database returns a nil slice if there are no items
@@ -65,22 +109,22 @@ func (p *PollAPI) GetSinglePollResource(c *gin.Context) {
 	//convert it to an int64 using the strconv package
 	idS := c.Param("id")
 	if idS == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No poll ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No poll ID provided")
 		return
 	}
 	id64, err := strconv.ParseInt(idS, 10, 32)
 	if err != nil {
-		log.Println("Error converting id to int64: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		slog.WarnContext(c.Request.Context(), "Error converting id to int64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid poll ID")
 		return
 	}
 
 	//Note that ParseInt always returns an int64, so we have to
 	//convert it to an int before we can use it.
-	poll, err := p.db.GetSinglePollResource(uint(id64))
+	poll, err := p.store(c).GetSinglePollResource(uint(id64))
 	if err != nil {
-		log.Println("Item not found: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
+		slog.WarnContext(c.Request.Context(), "Item not found", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
 
@@ -92,6 +136,11 @@ func (p *PollAPI) GetSinglePollResource(c *gin.Context) {
 // implementation for POST /todo
 // adds a new todo
 func (p *PollAPI) AddPoll(c *gin.Context) {
+	id, ok := parsePollId(c)
+	if !ok {
+		return
+	}
+
 	var poll db.Poll
 
 	//With HTTP based APIs, a POST request will usually
@@ -107,53 +156,346 @@ func (p *PollAPI) AddPoll(c *gin.Context) {
 	//the struct we are binding to.
 
 	if err := c.ShouldBindJSON(&poll); err != nil {
-		log.Println("Error binding JSON: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		slog.WarnContext(c.Request.Context(), "Error binding JSON", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	//The body may leave out the id, but it can't name a different poll
+	if poll.PollID == 0 {
+		poll.PollID = id
+	}
+	if poll.PollID != id {
+		web.AbortWithError(c, http.StatusBadRequest, "Poll ID in body does not match the URL")
+		return
+	}
+
+	if err := p.store(c).AddPoll(&poll); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error adding item", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+	pollsCreated.Inc()
+
+	c.JSON(http.StatusOK, poll)
+}
+
+// implementation for POST /polls
+// the server picks the poll id and returns it in the Location header
+func (p *PollAPI) CreatePoll(c *gin.Context) {
+	var poll db.Poll
+	if err := c.ShouldBindJSON(&poll); err != nil {
+		slog.WarnContext(c.Request.Context(), "Error binding JSON", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	if poll.PollID != 0 {
+		web.AbortWithError(c, http.StatusBadRequest, "Poll IDs are assigned by the server, use POST /polls/:id to choose one")
+		return
+	}
+
+	if err := p.store(c).CreatePoll(&poll); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error creating item", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+	pollsCreated.Inc()
+
+	c.Header("Location", fmt.Sprintf("/polls/%d", poll.PollID))
+	c.JSON(http.StatusCreated, poll)
+}
+
+func (p *PollAPI) UpdatePoll(c *gin.Context) {
+	idS := c.Param("id")
+	if idS == "" {
+		web.AbortWithError(c, http.StatusBadRequest, "No poll ID provided")
+		return
+	}
+	id64, err := strconv.ParseInt(idS, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to int64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid poll ID")
+		return
+	}
+
+	var poll db.Poll
+	if err := c.ShouldBindJSON(&poll); err != nil {
+		slog.WarnContext(c.Request.Context(), "Error binding JSON", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	//The body may leave out the id, but it can't name a different poll
+	if poll.PollID == 0 {
+		poll.PollID = uint(id64)
+	}
+	if poll.PollID != uint(id64) {
+		web.AbortWithError(c, http.StatusBadRequest, "Poll ID in body does not match the URL")
+		return
+	}
+
+	p.savePollUpdate(c, poll)
+}
+
+// implementation for PATCH /polls/:id
+// applies a JSON merge patch to a poll.  Options that already have votes
+// can't be changed or removed unless ?force=true is given
+func (p *PollAPI) PatchPoll(c *gin.Context) {
+	idS := c.Param("id")
+	if idS == "" {
+		web.AbortWithError(c, http.StatusBadRequest, "No poll ID provided")
+		return
+	}
+	id64, err := strconv.ParseInt(idS, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to int64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid poll ID")
+		return
+	}
+
+	patch, err := c.GetRawData()
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error reading body", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Could not read request body")
+		return
+	}
+
+	existing, err := p.store(c).GetSinglePollResource(uint(id64))
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Item not found", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	poll, err := db.ApplyMergePatch(existing, patch)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error applying patch", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid merge patch: "+err.Error())
+		return
+	}
+	if poll.PollID != existing.PollID {
+		web.AbortWithError(c, http.StatusBadRequest, "Poll ID cannot be changed")
+		return
+	}
+
+	p.savePollUpdate(c, poll)
+}
+
+// Helper shared by the PUT and PATCH handlers to store an updated poll
+func (p *PollAPI) savePollUpdate(c *gin.Context, poll db.Poll) {
+	existing, err := p.store(c).GetSinglePollResource(poll.PollID)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Item not found", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
 
-	if err := p.db.AddPoll(poll); err != nil {
-		log.Println("Error adding item: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	//A closed poll can't be changed whether or not it has votes, so say
+	//that rather than asking the votes API
+	if existing.Status == db.PollStatusClosed {
+		web.AbortWithStoreError(c, db.ErrPollClosed)
+		return
+	}
+
+	if c.Query("force") != "true" {
+		if status, err := p.checkVotedOptions(c.Request.Context(), existing, poll); err != nil {
+			slog.WarnContext(c.Request.Context(), "Rejecting poll update", "error", err)
+			web.AbortWithError(c, status, err.Error())
+			return
+		}
+	}
+
+	if err := p.store(c).UpdatePoll(&poll); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error updating item", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, poll)
 }
 
+// Helper to make sure an update doesn't rename or remove an option that
+// already has votes, which would change what those votes mean.  If an
+// error is returned, the status is the HTTP status code that should be
+// reported to the caller
+func (p *PollAPI) checkVotedOptions(ctx context.Context, existing db.Poll, updated db.Poll) (int, error) {
+	updatedText := make(map[uint]string, len(updated.PollOptions))
+	for _, option := range updated.PollOptions {
+		updatedText[option.PollOptionID] = option.PollOptionText
+	}
+
+	changed := make(map[uint]bool)
+	for _, option := range existing.PollOptions {
+		text, ok := updatedText[option.PollOptionID]
+		if !ok || text != option.PollOptionText {
+			changed[option.PollOptionID] = true
+		}
+	}
+
+	//Adding options or editing the title and question never affects
+	//votes, so there is no need to ask the votes API
+	if len(changed) == 0 {
+		return http.StatusOK, nil
+	}
+
+	results, err := p.fetchResults(ctx, existing.PollID)
+	if err != nil {
+		return http.StatusBadGateway, fmt.Errorf("Could not check votes for poll: %v", err)
+	}
+
+	var voted []uint
+	for _, result := range results.Results {
+		if changed[result.PollOptionID] && result.Count > 0 {
+			voted = append(voted, result.PollOptionID)
+		}
+	}
+	if len(voted) > 0 {
+		return http.StatusConflict, fmt.Errorf("Options %v already have votes, use ?force=true to change them anyway", voted)
+	}
+
+	return http.StatusOK, nil
+}
+
 // implementation for DELETE /todo/:id
-// deletes a todo
+// deletes a todo.  With ?cascade=true the votes cast on the poll are
+// deleted from the votes API and the poll is removed from every voter's
+// history in the voter API
 func (p *PollAPI) DeletePoll(c *gin.Context) {
 	idS := c.Param("id")
 	if idS == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No poll ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No poll ID provided")
 		return
 	}
-	id64, _ := strconv.ParseInt(idS, 10, 32)
+	id64, err := strconv.ParseInt(idS, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to int64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid poll ID")
+		return
+	}
+	cascade := c.Query("cascade") == "true"
 
-	if err := p.db.DeletePoll(uint(id64)); err != nil {
-		log.Println("Error deleting item: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	//The poll goes first so no new votes can be validated against it
+	//while we clean up.  When cascading, a poll that is already gone is
+	//not an error so a request whose cleanup failed part way can simply
+	//be repeated.  Any other failure stops before the other services
+	//are touched
+	err = p.store(c).DeletePoll(uint(id64))
+	if err != nil && !(cascade && errors.Is(err, storage.ErrNotFound)) {
+		slog.ErrorContext(c.Request.Context(), "Error deleting item", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
 
-	c.Status(http.StatusOK)
+	if !cascade {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	votesURL := fmt.Sprintf("%s/polls/%d/votes", p.votesAPIURL, id64)
+	numVotes, err := p.cascadeDelete(c.Request.Context(), votesURL)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error deleting votes for poll", "error", err)
+		web.AbortWithError(c, http.StatusBadGateway, "Poll deleted but its votes were not: "+err.Error())
+		return
+	}
+
+	historyURL := fmt.Sprintf("%s/voters/polls/%d", p.voterAPIURL, id64)
+	numVoters, err := p.cascadeDelete(c.Request.Context(), historyURL)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error removing poll from voter histories", "error", err)
+		web.AbortWithError(c, http.StatusBadGateway, "Poll deleted but voter histories were not updated: "+err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"votesDeleted": numVotes, "votersUpdated": numVoters})
+}
+
+// Helper to issue a cascading DELETE against another service.  Both the
+// votes API and the voter API report how many records they touched
+func (p *PollAPI) cascadeDelete(ctx context.Context, url string) (int, error) {
+	var result struct {
+		Deleted int `json:"deleted"`
+		Updated int `json:"updated"`
+	}
+
+	resp, err := p.apiClient.R().SetContext(detach(ctx)).SetResult(&result).Delete(url)
+	if err != nil {
+		return 0, err
+	}
+	if resp.IsError() {
+		return 0, fmt.Errorf("API returned %s for (%s)", resp.Status(), url)
+	}
+
+	return result.Deleted + result.Updated, nil
+}
+
+// implementation for POST /polls/:id/open
+// opens a draft poll for voting
+func (p *PollAPI) OpenPoll(c *gin.Context) {
+	p.changePollStatus(c, p.store(c).OpenPoll)
+}
+
+// implementation for POST /polls/:id/close
+// closes an open poll so it stops accepting votes and freezes its final
+// results
+func (p *PollAPI) ClosePoll(c *gin.Context) {
+	p.changePollStatus(c, func(id uint) (db.Poll, error) {
+		//The client hanging up mustn't leave a closed poll without its
+		//final results
+		return p.closePoll(detach(c.Request.Context()), id)
+	})
+}
+
+// Helper shared by the open and close handlers, change is the db function
+// that performs the status change
+func (p *PollAPI) changePollStatus(c *gin.Context, change func(uint) (db.Poll, error)) {
+	idS := c.Param("id")
+	if idS == "" {
+		web.AbortWithError(c, http.StatusBadRequest, "No poll ID provided")
+		return
+	}
+	id64, err := strconv.ParseInt(idS, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to int64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid poll ID")
+		return
+	}
+
+	poll, err := change(uint(id64))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error changing poll status", "error", err)
+		//A window that doesn't allow the change is a conflict with the
+		//poll's state here, not a bad request
+		switch {
+		case errors.Is(err, db.ErrInvalidWindow):
+			web.AbortWithError(c, http.StatusConflict, err.Error())
+		case errors.Is(err, errSnapshotFailed):
+			web.AbortWithError(c, http.StatusBadGateway, err.Error())
+		default:
+			web.AbortWithStoreError(c, err)
+		}
+		return
+	}
+	pollStatusChanges.WithLabelValues(string(poll.Status), triggerAPI).Inc()
+
+	c.JSON(http.StatusOK, poll)
 }
 
 // implementation for DELETE /todo
 // deletes all todos
 func (p *PollAPI) DeleteAllPolls(c *gin.Context) {
 
-	if err := p.db.DeleteAll(); err != nil {
-		log.Println("Error deleting all items: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	if err := p.store(c).DeleteAll(); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error deleting all items", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
 
 	c.Status(http.StatusOK)
 }
 
-/*   SPECIAL HANDLERS FOR DEMONSTRATION - CRASH SIMULATION AND HEALTH CHECK */
+/*   SPECIAL HANDLERS FOR DEMONSTRATION - CRASH SIMULATION */
 
 // implementation for GET /crash
 // This simulates a crash to show some of the benefits of the
@@ -162,18 +504,3 @@ func (p *PollAPI) CrashSim(c *gin.Context) {
 	//panic() is go's version of throwing an exception
 	panic("Simulating an unexpected crash")
 }
-
-// implementation of GET /health. It is a good practice to build in a
-// health check for your API.  Below the results are just hard coded
-// but in a real API you can provide detailed information about the
-// health of your API with a Health Check
-func (p *PollAPI) HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK,
-		gin.H{
-			"status":             "ok",
-			"version":            "1.0.0",
-			"uptime":             100,
-			"users_processed":    1000,
-			"errors_encountered": 10,
-		})
-}
@@ -0,0 +1,305 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"drexel.edu/common/web"
+	"drexel.edu/poll-api/db"
+	"github.com/gin-gonic/gin"
+)
+
+// fakeDownstream stands in for the votes and voter APIs.  It reports no
+// votes for any poll and counts the requests it gets by method and path
+type fakeDownstream struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (f *fakeDownstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.calls[r.Method+" "+r.URL.Path]++
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/results"):
+		json.NewEncoder(w).Encode(db.PollResults{})
+	case r.Method == http.MethodDelete:
+		w.Write([]byte(`{"deleted": 0}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeDownstream) count(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[key]
+}
+
+// Helper to build the poll routes on top of the memory store, with both
+// downstream APIs served by one fake
+func newTestRouter(t *testing.T) (*gin.Engine, *fakeDownstream) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	downstream := &fakeDownstream{calls: make(map[string]int)}
+	server := httptest.NewServer(downstream)
+	t.Cleanup(server.Close)
+
+	apiHandler, err := New(db.StoreMemory, db.RedisConfig{}, "", server.URL, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { apiHandler.Close() })
+
+	r := gin.New()
+	r.GET("/polls", apiHandler.GetAllPollResources)
+	r.GET("/polls/:id", apiHandler.GetSinglePollResource)
+	r.POST("/polls", apiHandler.CreatePoll)
+	r.PUT("/polls/:id", apiHandler.UpdatePoll)
+	r.PATCH("/polls/:id", apiHandler.PatchPoll)
+	r.DELETE("/polls/:id", apiHandler.DeletePoll)
+	r.POST("/polls/:id/open", apiHandler.OpenPoll)
+	r.POST("/polls/:id/close", apiHandler.ClosePoll)
+	return r, downstream
+}
+
+// Helper to send a request to the router and return the recorded response
+func serve(r *gin.Engine, method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// Helper to check a response's status and, for errors, its code
+func checkResponse(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, status, w.Body)
+	}
+	if code == "" {
+		return
+	}
+	var resp web.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error body %s: %v", w.Body, err)
+	}
+	if resp.Code != code {
+		t.Errorf("code = %q, want %q", resp.Code, code)
+	}
+}
+
+// Helper to create a draft poll with two options and return it
+func createPoll(t *testing.T, r *gin.Engine) db.Poll {
+	t.Helper()
+	w := serve(r, http.MethodPost, "/polls", `{"pollTitle": "Pets", "pollQuestion": "Favorite pet?",
+		"pollOptions": [{"pollOptionText": "Dog"}, {"pollOptionText": "Cat"}]}`)
+	checkResponse(t, w, http.StatusCreated, "")
+
+	var poll db.Poll
+	if err := json.Unmarshal(w.Body.Bytes(), &poll); err != nil {
+		t.Fatal(err)
+	}
+	return poll
+}
+
+func TestCreateAndGetPoll(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	created := createPoll(t, r)
+	if created.PollID == 0 || created.Status != db.PollStatusDraft {
+		t.Fatalf("created poll = %+v, want an id and draft status", created)
+	}
+	for i, option := range created.PollOptions {
+		if option.PollOptionID != uint(i+1) {
+			t.Errorf("option %d has id %d, want %d", i, option.PollOptionID, i+1)
+		}
+	}
+
+	w := serve(r, http.MethodGet, "/polls/1", "")
+	checkResponse(t, w, http.StatusOK, "")
+	var poll db.Poll
+	if err := json.Unmarshal(w.Body.Bytes(), &poll); err != nil {
+		t.Fatal(err)
+	}
+	if poll.PollTitle != "Pets" || len(poll.PollOptions) != 2 {
+		t.Errorf("GET returned %+v", poll)
+	}
+
+	w = serve(r, http.MethodGet, "/polls", "")
+	checkResponse(t, w, http.StatusOK, "")
+	var polls []db.Poll
+	if err := json.Unmarshal(w.Body.Bytes(), &polls); err != nil {
+		t.Fatal(err)
+	}
+	if len(polls) != 1 {
+		t.Errorf("listed %d polls, want 1", len(polls))
+	}
+}
+
+func TestPollRequestErrors(t *testing.T) {
+	r, _ := newTestRouter(t)
+	createPoll(t, r)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{"invalid id", http.MethodGet, "/polls/abc", "", http.StatusBadRequest, web.CodeInvalidRequest},
+		{"missing poll", http.MethodGet, "/polls/99", "", http.StatusNotFound, web.CodeNotFound},
+		{"invalid json", http.MethodPost, "/polls", `{"pollTitle": `, http.StatusBadRequest, web.CodeInvalidRequest},
+		{"client chosen id", http.MethodPost, "/polls", `{"pollId": 5, "pollTitle": "t"}`, http.StatusBadRequest, web.CodeInvalidRequest},
+		{"created closed", http.MethodPost, "/polls", `{"pollTitle": "t", "status": "closed"}`, http.StatusBadRequest, web.CodeInvalidRequest},
+		{"id mismatch", http.MethodPut, "/polls/1", `{"pollId": 2, "pollTitle": "t"}`, http.StatusBadRequest, web.CodeInvalidRequest},
+		{"patch changes id", http.MethodPatch, "/polls/1", `{"pollId": 2}`, http.StatusBadRequest, web.CodeInvalidRequest},
+		{"close a draft", http.MethodPost, "/polls/1/close", "", http.StatusConflict, web.CodeConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkResponse(t, serve(r, tt.method, tt.path, tt.body), tt.status, tt.code)
+		})
+	}
+}
+
+func TestPatchPoll(t *testing.T) {
+	r, _ := newTestRouter(t)
+	createPoll(t, r)
+
+	w := serve(r, http.MethodPatch, "/polls/1", `{"pollTitle": "Pets 2024"}`)
+	checkResponse(t, w, http.StatusOK, "")
+
+	var poll db.Poll
+	if err := json.Unmarshal(serve(r, http.MethodGet, "/polls/1", "").Body.Bytes(), &poll); err != nil {
+		t.Fatal(err)
+	}
+	if poll.PollTitle != "Pets 2024" || poll.PollQuestion != "Favorite pet?" || len(poll.PollOptions) != 2 {
+		t.Errorf("patched poll = %+v", poll)
+	}
+}
+
+func TestUpdateClosedPoll(t *testing.T) {
+	r, downstream := newTestRouter(t)
+	createPoll(t, r)
+
+	checkResponse(t, serve(r, http.MethodPost, "/polls/1/open", ""), http.StatusOK, "")
+	checkResponse(t, serve(r, http.MethodPost, "/polls/1/close", ""), http.StatusOK, "")
+	lookups := downstream.count("GET /polls/1/results")
+
+	//Removing an option would normally mean asking the votes API whether
+	//it has votes, but a closed poll is rejected before that
+	w := serve(r, http.MethodPut, "/polls/1", `{"pollTitle": "Pets", "pollOptions": [{"pollOptionId": 1, "pollOptionText": "Dog"}]}`)
+	checkResponse(t, w, http.StatusConflict, web.CodeConflict)
+	if got := downstream.count("GET /polls/1/results"); got != lookups {
+		t.Errorf("votes API was asked for results %d times, want %d", got, lookups)
+	}
+}
+
+func TestDeletePoll(t *testing.T) {
+	r, downstream := newTestRouter(t)
+	createPoll(t, r)
+
+	//A bad id must not reach the other services
+	checkResponse(t, serve(r, http.MethodDelete, "/polls/abc?cascade=true", ""), http.StatusBadRequest, web.CodeInvalidRequest)
+	if got := downstream.count("DELETE /polls/0/votes"); got != 0 {
+		t.Errorf("votes API got %d deletes for poll 0", got)
+	}
+
+	checkResponse(t, serve(r, http.MethodDelete, "/polls/1?cascade=true", ""), http.StatusOK, "")
+	if got := downstream.count("DELETE /polls/1/votes"); got != 1 {
+		t.Errorf("votes API got %d deletes, want 1", got)
+	}
+	if got := downstream.count("DELETE /voters/polls/1"); got != 1 {
+		t.Errorf("voter API got %d deletes, want 1", got)
+	}
+	checkResponse(t, serve(r, http.MethodGet, "/polls/1", ""), http.StatusNotFound, web.CodeNotFound)
+	checkResponse(t, serve(r, http.MethodDelete, "/polls/1", ""), http.StatusNotFound, web.CodeNotFound)
+}
+
+// failingDeleteStore is a store whose deletes fail the way they would if
+// the database could not be reached
+type failingDeleteStore struct {
+	db.PollStore
+}
+
+func (s failingDeleteStore) WithContext(ctx context.Context) db.PollStore {
+	return failingDeleteStore{s.PollStore.WithContext(ctx)}
+}
+
+func (s failingDeleteStore) DeletePoll(id uint) error {
+	return errors.New("connection refused")
+}
+
+func TestDeletePollStoreError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	downstream := &fakeDownstream{calls: make(map[string]int)}
+	server := httptest.NewServer(downstream)
+	defer server.Close()
+
+	apiHandler, err := New(db.StoreMemory, db.RedisConfig{}, "", server.URL, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer apiHandler.Close()
+	apiHandler.db = failingDeleteStore{apiHandler.db}
+
+	r := gin.New()
+	r.DELETE("/polls/:id", apiHandler.DeletePoll)
+
+	//Only a poll that is already gone lets a cascade go ahead
+	checkResponse(t, serve(r, http.MethodDelete, "/polls/1?cascade=true", ""), http.StatusInternalServerError, web.CodeInternalError)
+	if got := downstream.count("DELETE /polls/1/votes"); got != 0 {
+		t.Errorf("votes API got %d deletes after the poll was not deleted", got)
+	}
+	if got := downstream.count("DELETE /voters/polls/1"); got != 0 {
+		t.Errorf("voter API got %d deletes after the poll was not deleted", got)
+	}
+}
+
+func TestScheduledCloseTimesOut(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	//The votes API never answers, so the results snapshot can only end
+	//when the transition's context does
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	apiHandler, err := New(db.StoreMemory, db.RedisConfig{}, "", server.URL, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer apiHandler.Close()
+
+	poll := db.Poll{PollTitle: "Pets", Status: db.PollStatusOpen}
+	if err := apiHandler.db.AddPoll(&poll); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = apiHandler.runTransition(ctx, db.Transition{PollID: poll.PollID, Action: db.TransitionClose})
+	if !errors.Is(err, errSnapshotFailed) {
+		t.Errorf("err = %v, want %v so the close is retried", err, errSnapshotFailed)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("transition took %v after its context ended", elapsed)
+	}
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"drexel.edu/poll-api/db"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// The scheduler lock outlives a single pass so a slow pass can't overlap
+// with another replica, but expires quickly if this replica dies.  A pass
+// stops taking on transitions well before the lock expires, and each
+// transition gets a share of that, so one stuck call can't hold up the
+// rest or run on past the lock
+const (
+	schedulerLockTTL     = 30 * time.Second
+	schedulerPassTimeout = 20 * time.Second
+	transitionTimeout    = 10 * time.Second
+)
+
+// Scheduled transitions aren't part of any request, so each one starts a
+// trace of its own
+var tracer = otel.Tracer("drexel.edu/poll-api/api")
+
+var errSnapshotFailed = errors.New("poll closed but its final results could not be recorded")
+
+// RunScheduler opens and closes polls when their scheduled opensAt and
+// closesAt times arrive, checking every interval.  Every replica can run
+// it, a redis lock makes sure only one of them acts on each pass.  It
+// blocks until ctx is cancelled
+func (p *PollAPI) RunScheduler(ctx context.Context, interval time.Duration) {
+	token, err := newLockToken()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error starting scheduler", "error", err)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.runDueTransitions(token)
+		}
+	}
+}
+
+// Helper to carry out every transition that is due, if we get the lock
+func (p *PollAPI) runDueTransitions(token string) {
+	acquired, err := p.db.AcquireSchedulerLock(token, schedulerLockTTL)
+	if err != nil {
+		slog.Error("Error acquiring scheduler lock", "error", err)
+		return
+	}
+	if !acquired {
+		return
+	}
+	defer func() {
+		if err := p.db.ReleaseSchedulerLock(token); err != nil {
+			slog.Error("Error releasing scheduler lock", "error", err)
+		}
+	}()
+
+	due, err := p.db.DueTransitions(time.Now())
+	if err != nil {
+		slog.Error("Error reading poll schedule", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), schedulerPassTimeout)
+	defer cancel()
+
+	for i, t := range due {
+		//Whatever is left stays on the schedule for the next pass
+		if ctx.Err() != nil {
+			slog.Warn("Scheduler pass ran out of time", "remaining", len(due)-i)
+			return
+		}
+
+		poll, err := p.runTransition(ctx, t)
+
+		//If the results snapshot failed or the transition ran out of
+		//time, leave it on the schedule so the next pass can try again.
+		//The store keeps a close there too, for as long as the closed
+		//poll has no final results
+		if errors.Is(err, errSnapshotFailed) || errors.Is(err, context.DeadlineExceeded) {
+			slog.Error("Error running scheduled transition, will retry", "action", t.Action, "pollId", t.PollID, "error", err)
+			continue
+		}
+		//Any other failure means the poll is gone or was already moved
+		//by hand, so there is nothing left to do
+		if err != nil {
+			slog.Warn("Skipping scheduled transition", "action", t.Action, "pollId", t.PollID, "error", err)
+		} else {
+			pollStatusChanges.WithLabelValues(string(poll.Status), triggerScheduler).Inc()
+		}
+
+		if err := p.db.CompleteTransition(t); err != nil {
+			slog.Error("Error updating poll schedule", "error", err)
+		}
+	}
+}
+
+// Helper to carry out one scheduled transition in a span of its own,
+// giving up after transitionTimeout
+func (p *PollAPI) runTransition(ctx context.Context, t db.Transition) (db.Poll, error) {
+	ctx, cancel := context.WithTimeout(ctx, transitionTimeout)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "scheduled "+t.Action)
+	defer span.End()
+	span.SetAttributes(attribute.Int("poll.id", int(t.PollID)))
+
+	var poll db.Poll
+	var err error
+	switch t.Action {
+	case db.TransitionOpen:
+		poll, err = p.db.WithContext(ctx).OpenPoll(t.PollID)
+	case db.TransitionClose:
+		poll, err = p.closePoll(ctx, t.PollID)
+	default:
+		err = fmt.Errorf("unknown action %q", t.Action)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return poll, err
+}
+
+// Helper to close a poll and freeze its final results.  A poll that was
+// closed but whose results were never recorded is finished off, so a
+// failed close can simply be retried.  Everything is done under ctx, so
+// a caller that must not be cut short passes a detached one
+func (p *PollAPI) closePoll(ctx context.Context, id uint) (db.Poll, error) {
+	store := p.db.WithContext(ctx)
+	poll, err := store.ClosePoll(id)
+	if errors.Is(err, db.ErrInvalidTransition) {
+		existing, getErr := store.GetSinglePollResource(id)
+		if getErr != nil || existing.Status != db.PollStatusClosed || existing.FinalResults != nil {
+			return db.Poll{}, err
+		}
+		poll = existing
+	} else if err != nil {
+		return db.Poll{}, err
+	}
+
+	results, err := p.fetchResults(ctx, id)
+	if err != nil {
+		return poll, fmt.Errorf("%w: %v", errSnapshotFailed, err)
+	}
+	if err := store.FreezeResults(id, results); err != nil {
+		return poll, fmt.Errorf("%w: %v", errSnapshotFailed, err)
+	}
+
+	//Read the poll back so the caller sees the snapshot that was stored,
+	//which may be an earlier one if another close got there first
+	return store.GetSinglePollResource(id)
+}
+
+// Helper to get the current tally for a poll from GET /polls/:id/results
+// on the votes API
+func (p *PollAPI) fetchResults(ctx context.Context, id uint) (db.PollResults, error) {
+	resultsURL := fmt.Sprintf("%s/polls/%d/results", p.votesAPIURL, id)
+	var results db.PollResults
+
+	resp, err := p.apiClient.R().SetContext(ctx).SetResult(&results).Get(resultsURL)
+	if err != nil {
+		return db.PollResults{}, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return db.PollResults{}, fmt.Errorf("votes API returned %s for (%s)", resp.Status(), resultsURL)
+	}
+
+	return results, nil
+}
+
+// Helper to make a random token identifying this replica as lock holder
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"drexel.edu/common/storage"
+	"drexel.edu/common/web"
+	"github.com/gin-gonic/gin"
+)
+
+// implementation for GET /polls/search?q=
+// returns the polls whose title, question or options match every word
+// in q, either as a prefix or with one typo, best match first.
+// ?limit= caps the number of results
+func (p *PollAPI) SearchPolls(c *gin.Context) {
+	q := c.Query("q")
+	if q == "" {
+		web.AbortWithError(c, http.StatusBadRequest, "No search query provided, use ?q=")
+		return
+	}
+
+	limit := storage.DefaultPageLimit
+	if limitS, ok := c.GetQuery("limit"); ok {
+		var err error
+		limit, err = strconv.Atoi(limitS)
+		if err != nil || limit < 1 || limit > storage.MaxPageLimit {
+			web.AbortWithError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", storage.MaxPageLimit))
+			return
+		}
+	}
+
+	pollList, err := p.store(c).SearchPolls(q, limit)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error searching polls", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, pollList)
+}
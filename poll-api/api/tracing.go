@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"drexel.edu/poll-api/db"
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// ServiceName names this service in traces
+const ServiceName = "poll-api"
+
+// Tracing is middleware that starts a span for every request, joining the
+// trace of the caller if there is one
+func Tracing() gin.HandlerFunc {
+	return otelgin.Middleware(ServiceName)
+}
+
+// Helper to send the trace context along with the calls a resty client
+// makes, and give each call its own span
+func traceClient(client *resty.Client) *resty.Client {
+	transport := client.GetClient().Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return client.SetTransport(otelhttp.NewTransport(transport))
+}
+
+// Helper to keep the trace and request id in ctx but drop its
+// cancellation.  Calls to other services use it, so a client hanging up
+// can't leave a change half made in another service
+func detach(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
+}
+
+// Helper to get the store with the trace of the request, so the redis
+// commands it sends show up in the request's trace
+func (p *PollAPI) store(c *gin.Context) db.PollStore {
+	return p.db.WithContext(c.Request.Context())
+}
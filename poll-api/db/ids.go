@@ -0,0 +1,4 @@
+package db
+
+// Counter used to allocate poll ids on the server
+const RedisIdSeqKey = "poll-id-seq"
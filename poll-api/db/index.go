@@ -0,0 +1,5 @@
+package db
+
+// Sorted set holding every poll id scored by the id itself, so polls can
+// be listed in id order a page at a time
+const RedisIndexKey = "poll-index"
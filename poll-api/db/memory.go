@@ -0,0 +1,415 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"drexel.edu/common/storage"
+)
+
+// MemoryPollList keeps polls in process memory instead of redis, so the
+// API can run without a redis container.  Nothing survives a restart, it
+// can't be shared between replicas and no domain events are published
+type MemoryPollList struct {
+	mu         sync.Mutex
+	polls      map[uint]Poll
+	lastPollId uint
+	optionSeqs map[uint]uint
+	schedule   map[Transition]time.Time
+	lockToken  string
+	lockExpiry time.Time
+}
+
+func NewMemoryPollList() *MemoryPollList {
+	return &MemoryPollList{
+		polls:      make(map[uint]Poll),
+		optionSeqs: make(map[uint]uint),
+		schedule:   make(map[Transition]time.Time),
+	}
+}
+
+// Ping always succeeds, there is nothing to reach
+func (m *MemoryPollList) Ping() error {
+	return nil
+}
+
+// Close has nothing to release
+func (m *MemoryPollList) Close() error {
+	return nil
+}
+
+// WithContext returns the list itself, there are no calls to trace
+func (m *MemoryPollList) WithContext(ctx context.Context) PollStore {
+	return m
+}
+
+// Helper to copy a poll so callers never share slices with the store
+func clonePoll(poll Poll) Poll {
+	clone := poll
+	if poll.PollOptions != nil {
+		clone.PollOptions = append([]PollOption(nil), poll.PollOptions...)
+	}
+	if poll.OpensAt != nil {
+		opensAt := *poll.OpensAt
+		clone.OpensAt = &opensAt
+	}
+	if poll.ClosesAt != nil {
+		closesAt := *poll.ClosesAt
+		clone.ClosesAt = &closesAt
+	}
+	if poll.FinalResults != nil {
+		results := *poll.FinalResults
+		results.Results = append([]OptionResult(nil), poll.FinalResults.Results...)
+		clone.FinalResults = &results
+	}
+	return clone
+}
+
+// Helper to allocate a new option id for a poll, the caller holds mu
+func (m *MemoryPollList) nextOptionId(poll Poll) uint {
+	id := m.optionSeqs[poll.PollID]
+	if highest := highestOptionId(poll); id < highest {
+		id = highest
+	}
+	id++
+	m.optionSeqs[poll.PollID] = id
+	return id
+}
+
+// Helper to give every option that has no id yet a newly allocated one,
+// the caller holds mu
+func (m *MemoryPollList) assignOptionIds(poll *Poll) {
+	for i := range poll.PollOptions {
+		if poll.PollOptions[i].PollOptionID == 0 {
+			poll.PollOptions[i].PollOptionID = m.nextOptionId(*poll)
+		}
+	}
+}
+
+// Helper to store a poll and bring its schedule up to date, the caller
+// holds mu.  The option counter is raised to the highest option id on the
+// poll, so ids chosen by the client aren't handed out again once those
+// options have been removed
+func (m *MemoryPollList) savePoll(poll Poll) {
+	m.polls[poll.PollID] = clonePoll(poll)
+	if poll.PollID > m.lastPollId {
+		m.lastPollId = poll.PollID
+	}
+	if highest := highestOptionId(poll); highest > m.optionSeqs[poll.PollID] {
+		m.optionSeqs[poll.PollID] = highest
+	}
+
+	m.unschedulePoll(poll.PollID)
+	for transition, at := range pollTransitions(poll) {
+		m.schedule[transition] = at
+	}
+}
+
+// Helper to drop every scheduled transition for a poll, the caller holds mu
+func (m *MemoryPollList) unschedulePoll(id uint) {
+	delete(m.schedule, Transition{PollID: id, Action: TransitionOpen})
+	delete(m.schedule, Transition{PollID: id, Action: TransitionClose})
+}
+
+// Helper to list the polls in id order, the caller holds mu
+func (m *MemoryPollList) sortedIds() []uint {
+	ids := make([]uint, 0, len(m.polls))
+	for id := range m.polls {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Helper to change a stored poll with modify, the same way
+// PollList.modifyPoll does.  Closed polls can't be changed
+func (m *MemoryPollList) modifyPoll(id uint, modify func(*Poll) error) (Poll, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.polls[id]
+	if !ok {
+		return Poll{}, ErrPollNotFound
+	}
+	if stored.Status == PollStatusClosed {
+		return Poll{}, ErrPollClosed
+	}
+
+	poll := clonePoll(stored)
+	if err := modify(&poll); err != nil {
+		return Poll{}, err
+	}
+	m.savePoll(poll)
+
+	return clonePoll(poll), nil
+}
+
+func (m *MemoryPollList) AddPoll(poll *Poll) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.addPoll(poll)
+}
+
+func (m *MemoryPollList) CreatePoll(poll *Poll) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//Ids chosen by clients raise lastPollId too, so the next id is free
+	m.lastPollId++
+	poll.PollID = m.lastPollId
+	return m.addPoll(poll)
+}
+
+// Helper that does the work of AddPoll, the caller holds mu
+func (m *MemoryPollList) addPoll(poll *Poll) error {
+	if _, ok := m.polls[poll.PollID]; ok {
+		return ErrPollExists
+	}
+	if err := prepareNewPoll(poll); err != nil {
+		return err
+	}
+	m.assignOptionIds(poll)
+	m.savePoll(*poll)
+
+	return nil
+}
+
+func (m *MemoryPollList) UpdatePoll(poll *Poll) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.polls[poll.PollID]
+	if !ok {
+		return ErrPollNotFound
+	}
+	if err := prepareUpdatedPoll(existing, poll); err != nil {
+		return err
+	}
+	m.assignOptionIds(poll)
+	m.savePoll(*poll)
+
+	return nil
+}
+
+func (m *MemoryPollList) DeletePoll(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.polls[id]; !ok {
+		return ErrPollNotFound
+	}
+	delete(m.polls, id)
+	m.unschedulePoll(id)
+
+	return nil
+}
+
+func (m *MemoryPollList) DeleteAll() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.polls = make(map[uint]Poll)
+	m.schedule = make(map[Transition]time.Time)
+
+	return nil
+}
+
+func (m *MemoryPollList) GetSinglePollResource(id uint) (Poll, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	poll, ok := m.polls[id]
+	if !ok {
+		return Poll{}, ErrPollNotFound
+	}
+	return clonePoll(poll), nil
+}
+
+func (m *MemoryPollList) GetAllPolls() ([]Poll, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.getPolls(m.sortedIds()), nil
+}
+
+func (m *MemoryPollList) GetPollsPage(cursor string, limit int) ([]Poll, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids, next, err := storage.PageOfIds(m.sortedIds(), cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	return m.getPolls(ids), next, nil
+}
+
+// Helper to copy out the polls for a list of ids, the caller holds mu
+func (m *MemoryPollList) getPolls(ids []uint) []Poll {
+	pollList := make([]Poll, 0, len(ids))
+	for _, id := range ids {
+		pollList = append(pollList, clonePoll(m.polls[id]))
+	}
+	return pollList
+}
+
+func (m *MemoryPollList) SearchPolls(text string, limit int) ([]Poll, error) {
+	pollList, _ := m.GetAllPolls()
+	return rankPolls(pollList, text, limit)
+}
+
+func (m *MemoryPollList) OpenPoll(id uint) (Poll, error) {
+	return m.changeStatus(id, openPollAt)
+}
+
+func (m *MemoryPollList) ClosePoll(id uint) (Poll, error) {
+	return m.changeStatus(id, closePollAt)
+}
+
+// Helper shared by OpenPoll and ClosePoll
+func (m *MemoryPollList) changeStatus(id uint, change func(*Poll, time.Time) error) (Poll, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.polls[id]
+	if !ok {
+		return Poll{}, ErrPollNotFound
+	}
+
+	poll := clonePoll(stored)
+	if err := change(&poll, time.Now().UTC()); err != nil {
+		return Poll{}, err
+	}
+	m.savePoll(poll)
+
+	return clonePoll(poll), nil
+}
+
+func (m *MemoryPollList) FreezeResults(id uint, results PollResults) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	poll, ok := m.polls[id]
+	if !ok {
+		return ErrPollNotFound
+	}
+	if poll.FinalResults != nil {
+		return nil
+	}
+	poll.FinalResults = &results
+	m.savePoll(poll)
+
+	return nil
+}
+
+func (m *MemoryPollList) AddPollOption(pollId uint, text string) (PollOption, error) {
+	if text == "" {
+		return PollOption{}, ErrEmptyOptionText
+	}
+
+	var option PollOption
+	_, err := m.modifyPoll(pollId, func(poll *Poll) error {
+		option = PollOption{PollOptionID: m.nextOptionId(*poll), PollOptionText: text}
+		poll.PollOptions = append(poll.PollOptions, option)
+		return nil
+	})
+	if err != nil {
+		return PollOption{}, err
+	}
+
+	return option, nil
+}
+
+func (m *MemoryPollList) RenamePollOption(pollId uint, optionId uint, text string) (PollOption, error) {
+	if text == "" {
+		return PollOption{}, ErrEmptyOptionText
+	}
+
+	var renamed PollOption
+	_, err := m.modifyPoll(pollId, func(poll *Poll) error {
+		var err error
+		renamed, err = renameOption(poll, optionId, text)
+		return err
+	})
+	if err != nil {
+		return PollOption{}, err
+	}
+
+	return renamed, nil
+}
+
+func (m *MemoryPollList) RetirePollOption(pollId uint, optionId uint) (PollOption, error) {
+	var retired PollOption
+	_, err := m.modifyPoll(pollId, func(poll *Poll) error {
+		var err error
+		retired, err = retireOption(poll, optionId)
+		return err
+	})
+	if err != nil {
+		return PollOption{}, err
+	}
+
+	return retired, nil
+}
+
+func (m *MemoryPollList) ReorderPollOptions(pollId uint, order []uint) ([]PollOption, error) {
+	poll, err := m.modifyPoll(pollId, func(poll *Poll) error {
+		return reorderOptions(poll, order)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return poll.PollOptions, nil
+}
+
+func (m *MemoryPollList) DueTransitions(now time.Time) ([]Transition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//Like the redis schedule, times are compared to the second
+	var transitions []Transition
+	for transition, at := range m.schedule {
+		if at.Unix() <= now.Unix() {
+			transitions = append(transitions, transition)
+		}
+	}
+
+	sort.Slice(transitions, func(i, j int) bool {
+		return m.schedule[transitions[i]].Before(m.schedule[transitions[j]])
+	})
+	return transitions, nil
+}
+
+func (m *MemoryPollList) CompleteTransition(t Transition) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.schedule, t)
+	return nil
+}
+
+func (m *MemoryPollList) AcquireSchedulerLock(token string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.lockToken != "" && now.Before(m.lockExpiry) {
+		return false, nil
+	}
+	m.lockToken = token
+	m.lockExpiry = now.Add(ttl)
+	return true, nil
+}
+
+func (m *MemoryPollList) ReleaseSchedulerLock(token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lockToken == token {
+		m.lockToken = ""
+	}
+	return nil
+}
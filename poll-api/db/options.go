@@ -0,0 +1,294 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"drexel.edu/common/events"
+	"drexel.edu/common/storage"
+	"github.com/go-redis/redis/v8"
+)
+
+// Each poll has a counter that hands out option ids, so an id is never
+// used twice on the same poll, even after the option is retired
+const RedisOptionSeqPrefix = "poll-option-seq:"
+
+// How many times modifyPoll retries when another writer changes the poll
+// underneath it
+const maxModifyAttempts = 5
+
+var (
+	ErrPollNotFound    = storage.NewError(storage.ErrNotFound, "poll does not exist")
+	ErrOptionNotFound  = storage.NewError(storage.ErrNotFound, "poll option does not exist")
+	ErrDuplicateOption = storage.NewError(storage.ErrInvalid, "poll option ids must be unique")
+	ErrEmptyOptionText = storage.NewError(storage.ErrInvalid, "poll option text cannot be empty")
+	ErrInvalidOrder    = storage.NewError(storage.ErrInvalid, "option order must list every option id exactly once")
+)
+
+// nextOptionIdScript hands out the next option id for a poll.  The counter
+// never goes below the highest id already on the poll, which covers polls
+// whose options were created with ids chosen by the client
+//
+// KEYS[1] is the option counter, ARGV[1] is the highest id on the poll
+var nextOptionIdScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local highest = tonumber(ARGV[1])
+if current < highest then
+	current = highest
+end
+current = current + 1
+redis.call('SET', KEYS[1], current)
+return current
+`)
+
+func (c *cache) optionSeqKeyFromId(pollId uint) string {
+	return fmt.Sprintf("%s%d", c.key(RedisOptionSeqPrefix), pollId)
+}
+
+// Helper to find the highest option id on a poll
+func highestOptionId(poll Poll) uint {
+	var highest uint
+	for _, option := range poll.PollOptions {
+		if option.PollOptionID > highest {
+			highest = option.PollOptionID
+		}
+	}
+	return highest
+}
+
+// Helper to allocate a new option id for a poll
+func (lst *PollList) nextOptionId(poll Poll) (uint, error) {
+	id, err := nextOptionIdScript.Run(lst.context, lst.cacheClient,
+		[]string{lst.optionSeqKeyFromId(poll.PollID)}, highestOptionId(poll)).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
+
+// Helper to raise a poll's option counter to the highest option id on
+// it, so ids chosen by the client aren't handed out again once those
+// options have been removed
+func (lst *PollList) raiseOptionSeq(poll Poll) error {
+	return storage.RaiseIdSeq(lst.context, lst.cacheClient, lst.optionSeqKeyFromId(poll.PollID), highestOptionId(poll))
+}
+
+// Helper to give every option that has no id yet a newly allocated one
+func (lst *PollList) assignOptionIds(poll *Poll) error {
+	for i := range poll.PollOptions {
+		if poll.PollOptions[i].PollOptionID != 0 {
+			continue
+		}
+		id, err := lst.nextOptionId(*poll)
+		if err != nil {
+			return err
+		}
+		poll.PollOptions[i].PollOptionID = id
+	}
+	return nil
+}
+
+// Helper to read, change and write back a poll as one atomic step.
+// Closed polls can't be changed
+func (lst *PollList) modifyPoll(id uint, modify func(*Poll) error) (Poll, error) {
+	return lst.watchPoll(id, func(poll *Poll) error {
+		if poll.Status == PollStatusClosed {
+			return ErrPollClosed
+		}
+		return modify(poll)
+	})
+}
+
+// Helper to read, change and write back a poll whatever its status.  The
+// poll key is watched, so if another writer changes the poll first the
+// whole thing is retried with the fresh copy
+func (lst *PollList) watchPoll(id uint, modify func(*Poll) error) (Poll, error) {
+	redisKey := lst.redisKeyFromId(int(id))
+	var poll Poll
+
+	txf := func(tx *redis.Tx) error {
+		getCmd := redis.NewCmd(lst.context, "JSON.GET", redisKey, ".")
+		_ = tx.Process(lst.context, getCmd)
+		pollJSON, err := getCmd.Text()
+		if err != nil {
+			return storage.FromRedis(err, ErrPollNotFound)
+		}
+
+		poll = Poll{}
+		if err := json.Unmarshal([]byte(pollJSON), &poll); err != nil {
+			return storage.CorruptRecord(redisKey, err)
+		}
+		upgradePoll(&poll)
+
+		if err := modify(&poll); err != nil {
+			return err
+		}
+
+		updatedJSON, err := json.Marshal(poll)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(lst.context, func(pipe redis.Pipeliner) error {
+			pipe.Do(lst.context, "JSON.SET", redisKey, ".", string(updatedJSON))
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < maxModifyAttempts; attempt++ {
+		err := lst.cacheClient.Watch(lst.context, txf, redisKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return Poll{}, err
+		}
+
+		lst.publishEvent(events.PollUpdated, poll.PollID, poll)
+		return poll, nil
+	}
+
+	return Poll{}, storage.NewError(storage.ErrConflict, "poll kept changing while it was being updated")
+}
+
+// Helper to find an option on a poll by id
+func findOption(poll *Poll, optionId uint) (*PollOption, error) {
+	for i := range poll.PollOptions {
+		if poll.PollOptions[i].PollOptionID == optionId {
+			return &poll.PollOptions[i], nil
+		}
+	}
+	return nil, ErrOptionNotFound
+}
+
+// Helper to change the text of an option on a poll
+func renameOption(poll *Poll, optionId uint, text string) (PollOption, error) {
+	option, err := findOption(poll, optionId)
+	if err != nil {
+		return PollOption{}, err
+	}
+	option.PollOptionText = text
+	return *option, nil
+}
+
+// Helper to retire an option on a poll
+func retireOption(poll *Poll, optionId uint) (PollOption, error) {
+	option, err := findOption(poll, optionId)
+	if err != nil {
+		return PollOption{}, err
+	}
+	option.Retired = true
+	return *option, nil
+}
+
+// Helper to put the options of a poll in the given order
+func reorderOptions(poll *Poll, order []uint) error {
+	if len(order) != len(poll.PollOptions) {
+		return ErrInvalidOrder
+	}
+
+	reordered := make([]PollOption, 0, len(order))
+	used := make(map[uint]bool, len(order))
+	for _, optionId := range order {
+		option, err := findOption(poll, optionId)
+		if err != nil || used[optionId] {
+			return ErrInvalidOrder
+		}
+		used[optionId] = true
+		reordered = append(reordered, *option)
+	}
+
+	poll.PollOptions = reordered
+	return nil
+}
+
+/*
+Adds a new option to the poll with PollID = :id.  The option id is allocated
+here, never by the caller
+*/
+func (lst *PollList) AddPollOption(pollId uint, text string) (PollOption, error) {
+
+	if text == "" {
+		return PollOption{}, ErrEmptyOptionText
+	}
+
+	//Allocate the id up front, if the update fails the id is simply
+	//never used
+	current, err := lst.GetSinglePollResource(pollId)
+	if err != nil {
+		return PollOption{}, err
+	}
+	optionId, err := lst.nextOptionId(current)
+	if err != nil {
+		return PollOption{}, err
+	}
+
+	option := PollOption{PollOptionID: optionId, PollOptionText: text}
+	_, err = lst.modifyPoll(pollId, func(poll *Poll) error {
+		poll.PollOptions = append(poll.PollOptions, option)
+		return nil
+	})
+	if err != nil {
+		return PollOption{}, err
+	}
+
+	return option, nil
+}
+
+/*
+Changes the text of option :optionid on the poll with PollID = :id
+*/
+func (lst *PollList) RenamePollOption(pollId uint, optionId uint, text string) (PollOption, error) {
+
+	if text == "" {
+		return PollOption{}, ErrEmptyOptionText
+	}
+
+	var renamed PollOption
+	_, err := lst.modifyPoll(pollId, func(poll *Poll) error {
+		var err error
+		renamed, err = renameOption(poll, optionId, text)
+		return err
+	})
+	if err != nil {
+		return PollOption{}, err
+	}
+
+	return renamed, nil
+}
+
+/*
+Retires option :optionid on the poll with PollID = :id.  The option stays on
+the poll so its votes still count, but it accepts no new votes
+*/
+func (lst *PollList) RetirePollOption(pollId uint, optionId uint) (PollOption, error) {
+
+	var retired PollOption
+	_, err := lst.modifyPoll(pollId, func(poll *Poll) error {
+		var err error
+		retired, err = retireOption(poll, optionId)
+		return err
+	})
+	if err != nil {
+		return PollOption{}, err
+	}
+
+	return retired, nil
+}
+
+/*
+Puts the options of the poll with PollID = :id in the given order.  The
+order must list every option id, retired ones included, exactly once
+*/
+func (lst *PollList) ReorderPollOptions(pollId uint, order []uint) ([]PollOption, error) {
+
+	poll, err := lst.modifyPoll(pollId, func(poll *Poll) error {
+		return reorderOptions(poll, order)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return poll.PollOptions, nil
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"drexel.edu/common/storage"
+)
+
+func TestReorderOptions(t *testing.T) {
+	options := []PollOption{
+		{PollOptionID: 1, PollOptionText: "Dog"},
+		{PollOptionID: 2, PollOptionText: "Cat"},
+		{PollOptionID: 3, PollOptionText: "Fish", Retired: true},
+	}
+
+	tests := []struct {
+		name  string
+		order []uint
+		want  []uint
+		err   error
+	}{
+		{"same order", []uint{1, 2, 3}, []uint{1, 2, 3}, nil},
+		{"reversed", []uint{3, 2, 1}, []uint{3, 2, 1}, nil},
+		{"too few", []uint{2, 1}, nil, ErrInvalidOrder},
+		{"too many", []uint{1, 2, 3, 4}, nil, ErrInvalidOrder},
+		{"repeated id", []uint{1, 1, 2}, nil, ErrInvalidOrder},
+		{"unknown id", []uint{1, 2, 4}, nil, ErrInvalidOrder},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			poll := Poll{PollID: 1, PollOptions: append([]PollOption(nil), options...)}
+
+			err := reorderOptions(&poll, tt.order)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				//A rejected order leaves the options as they were
+				if !reflect.DeepEqual(poll.PollOptions, options) {
+					t.Errorf("options changed to %+v", poll.PollOptions)
+				}
+				return
+			}
+
+			var got []uint
+			for _, option := range poll.PollOptions {
+				got = append(got, option.PollOptionID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+			if !poll.PollOptions[0].Retired && tt.want[0] == 3 {
+				t.Error("option lost its retired flag")
+			}
+		})
+	}
+}
+
+func TestOptionIdsNotReused(t *testing.T) {
+	for _, kind := range []string{StoreMemory, StoreSQLite} {
+		t.Run(kind, func(t *testing.T) {
+			store, err := NewStore(kind, RedisConfig{}, filepath.Join(t.TempDir(), "polls.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			poll := Poll{PollID: 1, PollTitle: "Pets", PollOptions: []PollOption{
+				{PollOptionID: 1, PollOptionText: "Dog"},
+				{PollOptionID: 2, PollOptionText: "Cat"},
+			}}
+			if err := store.AddPoll(&poll); err != nil {
+				t.Fatal(err)
+			}
+
+			//Once option 2 is removed it is no longer the highest id on
+			//the poll, but it must still not be handed out again
+			update := Poll{PollID: 1, PollTitle: "Pets", PollOptions: []PollOption{
+				{PollOptionID: 1, PollOptionText: "Dog"},
+			}}
+			if err := store.UpdatePoll(&update); err != nil {
+				t.Fatal(err)
+			}
+
+			option, err := store.AddPollOption(1, "Fish")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if option.PollOptionID != 3 {
+				t.Errorf("new option has id %d, want 3", option.PollOptionID)
+			}
+
+			if _, err := store.AddPollOption(9, "Fish"); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("adding to a missing poll: err = %v, want %v", err, storage.ErrNotFound)
+			}
+		})
+	}
+}
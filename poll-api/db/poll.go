@@ -10,9 +10,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"time"
 
+	"drexel.edu/common/events"
+	"drexel.edu/common/storage"
 	"github.com/go-redis/redis/v8"
 	"github.com/nitishm/go-rejson/v4"
 )
@@ -23,22 +25,50 @@ const (
 	RedisKeyPrefix       = "poll:"
 )
 
+// A poll starts out as a draft, is opened for voting and is finally closed
+const (
+	PollStatusDraft  = "draft"
+	PollStatusOpen   = "open"
+	PollStatusClosed = "closed"
+)
+
+var (
+	ErrInvalidStatus     = storage.NewError(storage.ErrInvalid, "poll status must be draft, open or closed")
+	ErrInvalidWindow     = storage.NewError(storage.ErrInvalid, "poll must close after it opens")
+	ErrInvalidTransition = storage.NewError(storage.ErrConflict, "poll cannot move to that status")
+	ErrStatusReadOnly    = storage.NewError(storage.ErrInvalid, "poll status can only be changed by opening or closing the poll")
+	ErrPollClosed        = storage.NewError(storage.ErrConflict, "closed polls cannot be changed")
+	ErrCreatedClosed     = storage.NewError(storage.ErrInvalid, "polls cannot be created closed, open the poll and close it instead")
+	ErrPollExists        = storage.NewError(storage.ErrConflict, "poll already exists")
+)
+
 type cache struct {
 	cacheClient *redis.Client
 	jsonHelper  *rejson.Handler
 	context     context.Context
+	keyPrefix   string
 }
 
-type pollOption struct {
+// PollOption is one of the choices on a poll.  A retired option stays on
+// the poll so existing votes keep their meaning, but accepts no new votes
+type PollOption struct {
 	PollOptionID   uint   `json:"pollOptionId"`
 	PollOptionText string `json:"pollOptionText"`
+	Retired        bool   `json:"retired,omitempty"`
 }
 
+// Poll is a question with a set of options to vote on.  Votes are only
+// accepted while Status is open and, if they are set, between OpensAt and
+// ClosesAt.  FinalResults is filled in once the poll closes
 type Poll struct {
 	PollID       uint         `json:"pollId"`
 	PollTitle    string       `json:"pollTitle"`
 	PollQuestion string       `json:"pollQuestion"`
-	PollOptions  []pollOption `json:"pollOptions"`
+	PollOptions  []PollOption `json:"pollOptions"`
+	Status       string       `json:"status"`
+	OpensAt      *time.Time   `json:"opensAt,omitempty"`
+	ClosesAt     *time.Time   `json:"closesAt,omitempty"`
+	FinalResults *PollResults `json:"finalResults,omitempty"`
 }
 
 type PollList struct {
@@ -57,33 +87,110 @@ func NewSamplePoll(id uint, title string, question string) *Poll {
 		PollID:       id,
 		PollTitle:    title,
 		PollQuestion: question,
-		PollOptions:  []pollOption{},
+		PollOptions:  []PollOption{},
+		Status:       PollStatusDraft,
 	}
 }
 
-func NewPollList() (*PollList, error) {
+// Helper to check a poll before it is stored
+func (p *Poll) validate() error {
+	switch p.Status {
+	case PollStatusDraft, PollStatusOpen, PollStatusClosed:
+	default:
+		return ErrInvalidStatus
+	}
+
+	if p.OpensAt != nil && p.ClosesAt != nil && !p.ClosesAt.After(*p.OpensAt) {
+		return ErrInvalidWindow
+	}
+
+	//Options without an id are given one when the poll is stored
+	seen := make(map[uint]bool, len(p.PollOptions))
+	for _, option := range p.PollOptions {
+		if option.PollOptionID != 0 && seen[option.PollOptionID] {
+			return ErrDuplicateOption
+		}
+		seen[option.PollOptionID] = true
+	}
+
+	return nil
+}
+
+// The rules below are shared by every PollStore, the stores only differ
+// in how they read and write the polls
+
+// Helper to apply the defaults and checks for a poll that is being added
+func prepareNewPoll(poll *Poll) error {
+	//New polls are drafts unless the caller says otherwise
+	if poll.Status == "" {
+		poll.Status = PollStatusDraft
+	}
+	//Final results are only ever recorded when the poll closes, so a poll
+	//that started out closed would never get any
+	if poll.Status == PollStatusClosed {
+		return ErrCreatedClosed
+	}
+	poll.FinalResults = nil
+	return poll.validate()
+}
+
+// Helper to check a poll that is replacing existing.  The status and final
+// results are kept from existing, they only change by opening and closing
+// the poll
+func prepareUpdatedPoll(existing Poll, poll *Poll) error {
+	if existing.Status == PollStatusClosed {
+		return ErrPollClosed
+	}
+	if poll.Status != "" && poll.Status != existing.Status {
+		return ErrStatusReadOnly
+	}
+	poll.Status = existing.Status
+	poll.FinalResults = existing.FinalResults
+	return poll.validate()
+}
 
-	//We will use an override if the REDIS_URL is provided as an environment
-	//variable, which is the preferred way to wire up a docker container
-	redisUrl := os.Getenv("REDIS_URL")
-	//This handles the default condition
-	if redisUrl == "" {
-		redisUrl = RedisDefaultLocation
+// Helper to move a draft poll to open at now
+func openPollAt(poll *Poll, now time.Time) error {
+	if poll.Status != PollStatusDraft {
+		return ErrInvalidTransition
 	}
-	return NewWithCacheInstance(redisUrl)
+	if poll.ClosesAt != nil && !poll.ClosesAt.After(now) {
+		return ErrInvalidWindow
+	}
+	if poll.OpensAt == nil || poll.OpensAt.After(now) {
+		poll.OpensAt = &now
+	}
+	poll.Status = PollStatusOpen
+	return nil
+}
 
+// Helper to move an open poll to closed at now
+func closePollAt(poll *Poll, now time.Time) error {
+	if poll.Status != PollStatusOpen {
+		return ErrInvalidTransition
+	}
+	if poll.ClosesAt == nil || poll.ClosesAt.After(now) {
+		poll.ClosesAt = &now
+	}
+	poll.Status = PollStatusClosed
+	return nil
 }
 
 // NewWithCacheInstance is a constructor function that returns a pointer to a new
 // ToDo struct.  It accepts a string that represents the location of the redis
 // cache.
 func NewWithCacheInstance(location string) (*PollList, error) {
+	return NewWithConfig(RedisConfig{Addr: location})
+}
 
-	//Connect to redis.  Other options can be provided, but the
-	//defaults are OK
-	client := redis.NewClient(&redis.Options{
-		Addr: location,
-	})
+// NewWithConfig connects to the redis described by cfg and returns a list
+// that keeps polls there
+func NewWithConfig(cfg RedisConfig) (*PollList, error) {
+
+	//Connect to redis
+	client := redis.NewClient(redisOptions(cfg))
+	client.AddHook(metricsHook{})
+	client.AddHook(tracingHook{})
 
 	//We use this context to coordinate betwen our go code and
 	//the redis operaitons
@@ -93,7 +200,7 @@ func NewWithCacheInstance(location string) (*PollList, error) {
 	//is working
 	err := client.Ping(ctx).Err()
 	if err != nil {
-		log.Println("Error connecting to redis" + err.Error())
+		slog.Error("Error connecting to redis", "error", err)
 		return nil, err
 	}
 
@@ -106,14 +213,122 @@ func NewWithCacheInstance(location string) (*PollList, error) {
 	jsonHelper := rejson.NewReJSONHandler()
 	jsonHelper.SetGoRedisClientWithContext(ctx, client)
 
+	pollList := &PollList{
+		cache: cache{
+			cacheClient: client,
+			jsonHelper:  jsonHelper,
+			context:     ctx,
+			keyPrefix:   cfg.KeyPrefix,
+		},
+	}
+
+	//Polls stored before the index existed still need to be listed, and
+	//rebuilding the schedule below reads the polls through it
+	if err := storage.RebuildIndex(pollList.context, pollList.cacheClient, pollList.key(RedisIndexKey), pollList.key(RedisKeyPrefix)); err != nil {
+		slog.Error("Error rebuilding poll index", "error", err)
+		return nil, err
+	}
+
+	//Polls stored before the scheduler existed still need their
+	//opening and closing times on the schedule
+	if err := pollList.rebuildSchedule(); err != nil {
+		slog.Error("Error rebuilding poll schedule", "error", err)
+		return nil, err
+	}
+
+	//Server allocated ids have to start past the polls already stored
+	if err := storage.RebuildIdSeq(pollList.context, pollList.cacheClient, pollList.key(RedisIdSeqKey), pollList.key(RedisKeyPrefix)); err != nil {
+		slog.Error("Error rebuilding poll id sequence", "error", err)
+		return nil, err
+	}
+
+	//Search is only an extra, so a redis without RediSearch is not fatal
+	if err := pollList.ensureSearchIndex(); err != nil {
+		slog.Error("Error creating poll search index, search is disabled", "error", err)
+	}
+
 	//Return a pointer to a new ToDo struct
+	return pollList, nil
+}
+
+// ApplyMergePatch applies a JSON merge patch (RFC 7396) to a poll and
+// returns the patched copy.  Fields set to null in the patch are removed
+// and objects are merged recursively, anything else replaces the value
+func ApplyMergePatch(poll Poll, patch []byte) (Poll, error) {
+	var patchDoc interface{}
+	if err := json.Unmarshal(patch, &patchDoc); err != nil {
+		return Poll{}, err
+	}
+
+	pollJSON, err := json.Marshal(poll)
+	if err != nil {
+		return Poll{}, err
+	}
+	var pollDoc interface{}
+	if err := json.Unmarshal(pollJSON, &pollDoc); err != nil {
+		return Poll{}, err
+	}
+
+	mergedJSON, err := json.Marshal(mergePatch(pollDoc, patchDoc))
+	if err != nil {
+		return Poll{}, err
+	}
+	var patched Poll
+	if err := json.Unmarshal(mergedJSON, &patched); err != nil {
+		return Poll{}, err
+	}
+
+	return patched, nil
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+
+	return targetObj
+}
+
+// Ping checks that redis can still be reached
+func (lst *PollList) Ping() error {
+	return lst.cacheClient.Ping(lst.context).Err()
+}
+
+// Close closes the redis client.  Copies made by WithContext share the
+// client, so it only needs closing once
+func (lst *PollList) Close() error {
+	return lst.cacheClient.Close()
+}
+
+// WithContext returns a copy of the list that sends its commands with the
+// trace and request id in ctx, so they show up in the request's trace and
+// logs.  ctx's deadline and cancellation are not used
+func (lst *PollList) WithContext(ctx context.Context) PollStore {
+	ctx = detach(ctx)
+	jsonHelper := rejson.NewReJSONHandler()
+	jsonHelper.SetGoRedisClientWithContext(ctx, lst.cacheClient)
+
 	return &PollList{
 		cache: cache{
-			cacheClient: client,
+			cacheClient: lst.cacheClient,
 			jsonHelper:  jsonHelper,
 			context:     ctx,
+			keyPrefix:   lst.keyPrefix,
 		},
-	}, nil
+	}
 }
 
 //------------------------------------------------------------
@@ -128,8 +343,8 @@ func isRedisNilError(err error) bool {
 // In redis, our keys will be strings, they will look like
 // todo:<number>.  This function will take an integer and
 // return a string that can be used as a key in redis
-func redisKeyFromId(id int) string {
-	return fmt.Sprintf("%s%d", RedisKeyPrefix, id)
+func (c *cache) redisKeyFromId(id int) string {
+	return fmt.Sprintf("%s%d", c.key(RedisKeyPrefix), id)
 }
 
 // Helper to return a ToDoItem from redis provided a key
@@ -140,7 +355,7 @@ func (p *PollList) getItemFromRedis(key string, item *Poll) error {
 	//json structure
 	itemObject, err := p.jsonHelper.JSONGet(key, ".")
 	if err != nil {
-		return err
+		return storage.FromRedis(err, ErrPollNotFound)
 	}
 
 	//JSONGet returns an "any" object, or empty interface,
@@ -149,61 +364,139 @@ func (p *PollList) getItemFromRedis(key string, item *Poll) error {
 	//it into our ToDoItem struct
 	err = json.Unmarshal(itemObject.([]byte), item)
 	if err != nil {
-		return nil
+		return storage.CorruptRecord(key, err)
 	}
 
+	upgradePoll(item)
+
 	return nil
 }
 
+// Helper to fill in fields that polls stored by older versions lack
+func upgradePoll(item *Poll) {
+	//Polls stored before they had a lifecycle always accepted votes
+	if item.Status == "" {
+		item.Status = PollStatusOpen
+	}
+}
+
 //------------------------------------------------------------
 // THESE ARE THE PUBLIC FUNCTIONS THAT SUPPORT OUR VOTER APP
 //------------------------------------------------------------
 
-func (lst *PollList) AddPoll(poll Poll) error {
+func (lst *PollList) AddPoll(poll *Poll) error {
 
 	//Before we add an item to the DB, lets make sure
 	//it does not exist, if it does, return an error
 
-	redisKey := redisKeyFromId(int(poll.PollID))
+	redisKey := lst.redisKeyFromId(int(poll.PollID))
+	//A poll that can't be read still takes up the id
 	var existingPoll Poll
-	if err := lst.getItemFromRedis(redisKey, &existingPoll); err == nil {
-		return errors.New("voter already exists")
+	switch err := lst.getItemFromRedis(redisKey, &existingPoll); {
+	case err == nil, errors.Is(err, storage.ErrCorruptRecord):
+		return ErrPollExists
+	case !errors.Is(err, ErrPollNotFound):
+		return err
+	}
+
+	if err := prepareNewPoll(poll); err != nil {
+		return err
+	}
+	if err := lst.assignOptionIds(poll); err != nil {
+		return err
 	}
 
 	//Add item to database with JSON Set
 	if _, err := lst.jsonHelper.JSONSet(redisKey, ".", poll); err != nil {
 		return err
 	}
+	if err := storage.IndexId(lst.context, lst.cacheClient, lst.key(RedisIndexKey), poll.PollID); err != nil {
+		return err
+	}
+	if err := storage.RaiseIdSeq(lst.context, lst.cacheClient, lst.key(RedisIdSeqKey), poll.PollID); err != nil {
+		return err
+	}
+	if err := lst.raiseOptionSeq(*poll); err != nil {
+		return err
+	}
+	if err := lst.schedulePoll(*poll); err != nil {
+		return err
+	}
+	lst.publishEvent(events.PollCreated, poll.PollID, *poll)
 
 	//If everything is ok, return nil for the error
 	return nil
 }
 
+// CreatePoll adds a poll under the next server allocated id and writes
+// that id back into the poll
+func (lst *PollList) CreatePoll(poll *Poll) error {
+	for attempt := 0; attempt < storage.MaxIdAttempts; attempt++ {
+		id, err := storage.NextId(lst.context, lst.cacheClient, lst.key(RedisIdSeqKey))
+		if err != nil {
+			return err
+		}
+		poll.PollID = id
+
+		//A client may have claimed this id through POST /polls/:id
+		//between the increment and the write, if so just take the next one
+		if err := lst.AddPoll(poll); !errors.Is(err, ErrPollExists) {
+			return err
+		}
+	}
+	return errors.New("could not allocate a poll id")
+}
+
 func (lst *PollList) DeletePoll(id uint) error {
 
-	pattern := redisKeyFromId(int(id))
+	pattern := lst.redisKeyFromId(int(id))
 	numDeleted, err := lst.cacheClient.Del(lst.context, pattern).Result()
 	if err != nil {
 		return err
 	}
 	if numDeleted == 0 {
-		return errors.New("attempted to delete non-existent item")
+		return ErrPollNotFound
+	}
+	if err := storage.UnindexId(lst.context, lst.cacheClient, lst.key(RedisIndexKey), id); err != nil {
+		return err
+	}
+	if err := lst.unschedulePoll(id); err != nil {
+		return err
 	}
+	lst.publishEvent(events.PollDeleted, id, nil)
 
 	return nil
 }
 
 func (lst *PollList) DeleteAll() error {
-	pattern := RedisKeyPrefix + "*"
-	ks, _ := lst.cacheClient.Keys(lst.context, pattern).Result()
+	pattern := lst.key(RedisKeyPrefix) + "*"
+	ks, err := storage.ScanKeys(lst.context, lst.cacheClient, pattern)
+	if err != nil {
+		return err
+	}
+
 	//Note delete can take a collection of keys.  In go we can
 	//expand a slice into individual arguments by using the ...
-	//operator
-	numDeleted, err := lst.cacheClient.Del(lst.context, ks...).Result()
-	if err != nil {
+	//operator.  Redis rejects a DEL without any keys, so only
+	//call it when there is something to delete
+	var numDeleted int64
+	if len(ks) > 0 {
+		numDeleted, err = lst.cacheClient.Del(lst.context, ks...).Result()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := lst.cacheClient.Del(lst.context, lst.key(RedisScheduleKey), lst.key(RedisIndexKey)).Err(); err != nil {
 		return err
 	}
 
+	for _, key := range ks {
+		if id, err := storage.IdFromKey(key, lst.key(RedisKeyPrefix)); err == nil {
+			lst.publishEvent(events.PollDeleted, id, nil)
+		}
+	}
+
 	if numDeleted != int64(len(ks)) {
 		return errors.New("one or more items could not be deleted")
 	}
@@ -221,7 +514,7 @@ func (lst *PollList) GetSinglePollResource(id uint) (Poll, error) {
 	// this is a good practice, return an error if the
 	// item does not exist
 	var poll Poll
-	pattern := redisKeyFromId(int(id))
+	pattern := lst.redisKeyFromId(int(id))
 	err := lst.getItemFromRedis(pattern, &poll)
 	if err != nil {
 		return Poll{}, err
@@ -236,20 +529,115 @@ discuss the concept of "paging" later, for now you can ignore)
 */
 func (lst *PollList) GetAllPolls() ([]Poll, error) {
 
+	//The index keeps the ids in order, so the polls come back sorted
+	ids, err := storage.SetIds(lst.context, lst.cacheClient, lst.key(RedisIndexKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return lst.getPolls(ids)
+}
+
+// GetPollsPage returns up to limit polls with ids after cursor, along
+// with the cursor for the next page.  The returned cursor is empty once
+// the last page has been read
+func (lst *PollList) GetPollsPage(cursor string, limit int) ([]Poll, string, error) {
+	ids, next, err := storage.RangeIds(lst.context, lst.cacheClient, lst.key(RedisIndexKey), cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pollList, err := lst.getPolls(ids)
+	if err != nil {
+		return nil, "", err
+	}
+	return pollList, next, nil
+}
+
+// Helper to load the polls for a list of ids, skipping any that were
+// deleted since the ids were read.  A record that can't be decoded fails
+// the whole read rather than quietly going missing from the list
+func (lst *PollList) getPolls(ids []uint) ([]Poll, error) {
+
+	docs, err := storage.GetDocuments(lst.jsonHelper, lst.key(RedisKeyPrefix), ids)
+	if err != nil {
+		return nil, err
+	}
+
 	//Now that we have the DB loaded, lets crate a slice
-	var pollList []Poll
+	pollList := make([]Poll, 0, len(docs))
 
-	//Lets query redis for all of the items
-	pattern := RedisKeyPrefix + "*"
-	ks, _ := lst.cacheClient.Keys(lst.context, pattern).Result()
-	for _, key := range ks {
+	for _, doc := range docs {
 		var poll Poll
-		err := lst.getItemFromRedis(key, &poll)
-		if err != nil {
-			return nil, err
+		if err := json.Unmarshal(doc.JSON, &poll); err != nil {
+			return nil, storage.CorruptRecord(doc.Key, err)
 		}
+		upgradePoll(&poll)
 		pollList = append(pollList, poll)
 	}
 
 	return pollList, nil
 }
+
+/*
+Replaces the poll with the same PollID.  The status and final results are
+kept from the stored poll, they only change by opening and closing it.  A
+closed poll can't be changed at all
+*/
+func (lst *PollList) UpdatePoll(poll *Poll) error {
+
+	//The stored poll is watched, so a close that lands between reading it
+	//and writing the replacement can't be overwritten with a stale status
+	updated, err := lst.watchPoll(poll.PollID, func(existing *Poll) error {
+		replacement := *poll
+		if err := prepareUpdatedPoll(*existing, &replacement); err != nil {
+			return err
+		}
+		if err := lst.assignOptionIds(&replacement); err != nil {
+			return err
+		}
+		*existing = replacement
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*poll = updated
+
+	if err := lst.raiseOptionSeq(updated); err != nil {
+		return err
+	}
+	return lst.schedulePoll(updated)
+}
+
+/*
+Opens the poll with PollID = :id for voting.  Only a draft poll can be
+opened.  If the poll was scheduled to open later, it opens now instead
+*/
+func (lst *PollList) OpenPoll(id uint) (Poll, error) {
+	return lst.changeStatus(id, openPollAt)
+}
+
+/*
+Closes the poll with PollID = :id so it no longer accepts votes.  Only an
+open poll can be closed.  If the poll was scheduled to close later, it
+closes now instead
+*/
+func (lst *PollList) ClosePoll(id uint) (Poll, error) {
+	return lst.changeStatus(id, closePollAt)
+}
+
+// Helper shared by OpenPoll and ClosePoll
+func (lst *PollList) changeStatus(id uint, change func(*Poll, time.Time) error) (Poll, error) {
+	poll, err := lst.watchPoll(id, func(poll *Poll) error {
+		return change(poll, time.Now().UTC())
+	})
+	if err != nil {
+		return Poll{}, err
+	}
+	if err := lst.schedulePoll(poll); err != nil {
+		return Poll{}, err
+	}
+
+	return poll, nil
+}
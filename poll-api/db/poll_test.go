@@ -0,0 +1,98 @@
+package db
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestApplyMergePatch(t *testing.T) {
+	poll := Poll{
+		PollID:       1,
+		PollTitle:    "Pets",
+		PollQuestion: "Favorite pet?",
+		PollOptions: []PollOption{
+			{PollOptionID: 1, PollOptionText: "Dog"},
+			{PollOptionID: 2, PollOptionText: "Cat"},
+		},
+		Status: PollStatusDraft,
+	}
+
+	tests := []struct {
+		name  string
+		patch string
+		want  func(p *Poll)
+	}{
+		{"empty patch", `{}`, func(p *Poll) {}},
+		{"replace a field", `{"pollTitle": "Animals"}`, func(p *Poll) { p.PollTitle = "Animals" }},
+		{"null removes a field", `{"pollQuestion": null}`, func(p *Poll) { p.PollQuestion = "" }},
+		{"arrays are replaced whole", `{"pollOptions": [{"pollOptionId": 2, "pollOptionText": "Cat"}]}`, func(p *Poll) {
+			p.PollOptions = []PollOption{{PollOptionID: 2, PollOptionText: "Cat"}}
+		}},
+		{"several fields", `{"pollTitle": "Animals", "status": "open"}`, func(p *Poll) {
+			p.PollTitle = "Animals"
+			p.Status = PollStatusOpen
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := poll
+			want.PollOptions = append([]PollOption(nil), poll.PollOptions...)
+			tt.want(&want)
+
+			got, err := ApplyMergePatch(poll, []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	if _, err := ApplyMergePatch(poll, []byte(`{"pollTitle": `)); err == nil {
+		t.Error("invalid JSON was accepted")
+	}
+	if _, err := ApplyMergePatch(poll, []byte(`{"pollTitle": 5}`)); err == nil {
+		t.Error("patch giving the title the wrong type was accepted")
+	}
+}
+
+// The examples from RFC 7396 appendix A
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	decode := func(s string) interface{} {
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		return v
+	}
+
+	for _, tt := range tests {
+		got := mergePatch(decode(tt.target), decode(tt.patch))
+		if want := decode(tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("merging %s into %s gave %v, want %v", tt.patch, tt.target, got, want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"crypto/tls"
+	"net"
+
+	"drexel.edu/common/config"
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisConfig says how to reach redis.  It is part of the service's
+// configuration
+type RedisConfig = config.RedisConfig
+
+// Helper to turn the config into options for the redis client
+func redisOptions(cfg RedisConfig) *redis.Options {
+	opts := &redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	}
+	if cfg.TLS {
+		//The certificate is checked against the host we connect to
+		host, _, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			host = cfg.Addr
+		}
+		opts.TLSConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+	return opts
+}
+
+// Helper to put the configured prefix in front of a redis key
+func (c *cache) key(name string) string {
+	return c.keyPrefix + name
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"drexel.edu/common/events"
+	"github.com/go-redis/redis/v8"
+	"github.com/nitishm/go-rejson/v4/rjs"
+)
+
+const (
+	// Sorted set of upcoming status changes, scored by when they are due
+	RedisScheduleKey = "poll-schedule"
+
+	// Only the replica holding this lock runs the scheduled changes
+	RedisSchedulerLockKey = "poll-scheduler-lock"
+)
+
+// The status changes the scheduler can make
+const (
+	TransitionOpen  = "open"
+	TransitionClose = "close"
+)
+
+// Transition is a scheduled status change for a poll
+type Transition struct {
+	PollID uint
+	Action string
+}
+
+// OptionResult is the tally for a single poll option
+type OptionResult struct {
+	PollOptionID   uint    `json:"pollOptionId"`
+	PollOptionText string  `json:"pollOptionText"`
+	Retired        bool    `json:"retired,omitempty"`
+	Count          uint    `json:"count"`
+	Percentage     float64 `json:"percentage"`
+}
+
+// PollResults is the tally of a poll as reported by the votes API.  It is
+// frozen onto the poll when the poll closes
+type PollResults struct {
+	PollID       uint           `json:"pollId"`
+	PollTitle    string         `json:"pollTitle"`
+	PollQuestion string         `json:"pollQuestion"`
+	TotalVotes   uint           `json:"totalVotes"`
+	InvalidVotes uint           `json:"invalidVotes"`
+	Results      []OptionResult `json:"results"`
+}
+
+// releaseLockScript deletes the lock only if we still hold it, so a
+// replica whose lock expired can't release the lock another replica took
+//
+// KEYS[1] is the lock key, ARGV[1] is the token of the holder
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Scheduled transitions are stored as members like open:3 or close:3
+func (t Transition) member() string {
+	return fmt.Sprintf("%s:%d", t.Action, t.PollID)
+}
+
+func transitionFromMember(member string) (Transition, error) {
+	action, idS, found := strings.Cut(member, ":")
+	if !found {
+		return Transition{}, fmt.Errorf("malformed schedule entry %q", member)
+	}
+	id, err := strconv.ParseUint(idS, 10, 32)
+	if err != nil {
+		return Transition{}, err
+	}
+	return Transition{PollID: uint(id), Action: action}, nil
+}
+
+// Helper to work out which transitions a poll needs and when.  A draft
+// poll with an opening time is scheduled to open, and any poll that has a
+// closing time is scheduled to close until its final results are frozen.
+// That keeps a close whose results snapshot failed on the schedule, so the
+// scheduler tries again
+func pollTransitions(poll Poll) map[Transition]time.Time {
+	due := make(map[Transition]time.Time)
+	if poll.Status == PollStatusDraft && poll.OpensAt != nil {
+		due[Transition{PollID: poll.PollID, Action: TransitionOpen}] = *poll.OpensAt
+	}
+	if poll.FinalResults == nil && poll.ClosesAt != nil {
+		due[Transition{PollID: poll.PollID, Action: TransitionClose}] = *poll.ClosesAt
+	}
+	return due
+}
+
+// Helper to bring the schedule in line with the poll's status and window
+func (lst *PollList) schedulePoll(poll Poll) error {
+	if err := lst.unschedulePoll(poll.PollID); err != nil {
+		return err
+	}
+
+	var entries []*redis.Z
+	for transition, at := range pollTransitions(poll) {
+		entries = append(entries, &redis.Z{Score: float64(at.Unix()), Member: transition.member()})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return lst.cacheClient.ZAdd(lst.context, lst.key(RedisScheduleKey), entries...).Err()
+}
+
+// Helper to drop every scheduled transition for a poll
+func (lst *PollList) unschedulePoll(id uint) error {
+	opening := Transition{PollID: id, Action: TransitionOpen}
+	closing := Transition{PollID: id, Action: TransitionClose}
+	return lst.cacheClient.ZRem(lst.context, lst.key(RedisScheduleKey), opening.member(), closing.member()).Err()
+}
+
+// Helper to make sure every stored poll is on the schedule
+func (lst *PollList) rebuildSchedule() error {
+	pollList, err := lst.GetAllPolls()
+	if err != nil {
+		return err
+	}
+
+	for _, poll := range pollList {
+		if err := lst.schedulePoll(poll); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DueTransitions returns the scheduled transitions that are due at now,
+// oldest first
+func (lst *PollList) DueTransitions(now time.Time) ([]Transition, error) {
+	members, err := lst.cacheClient.ZRangeByScore(lst.context, lst.key(RedisScheduleKey), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var transitions []Transition
+	for _, member := range members {
+		transition, err := transitionFromMember(member)
+		if err != nil {
+			//Nothing can ever run a malformed entry, so drop it
+			lst.cacheClient.ZRem(lst.context, lst.key(RedisScheduleKey), member)
+			continue
+		}
+		transitions = append(transitions, transition)
+	}
+
+	return transitions, nil
+}
+
+// CompleteTransition removes a transition from the schedule once it has
+// been carried out
+func (lst *PollList) CompleteTransition(t Transition) error {
+	return lst.cacheClient.ZRem(lst.context, lst.key(RedisScheduleKey), t.member()).Err()
+}
+
+// AcquireSchedulerLock tries to take the scheduler lock for ttl.  token
+// identifies the holder and must be passed to ReleaseSchedulerLock
+func (lst *PollList) AcquireSchedulerLock(token string, ttl time.Duration) (bool, error) {
+	return lst.cacheClient.SetNX(lst.context, lst.key(RedisSchedulerLockKey), token, ttl).Result()
+}
+
+// ReleaseSchedulerLock gives up the scheduler lock if token still holds it
+func (lst *PollList) ReleaseSchedulerLock(token string) error {
+	return releaseLockScript.Run(lst.context, lst.cacheClient,
+		[]string{lst.key(RedisSchedulerLockKey)}, token).Err()
+}
+
+// FreezeResults stores the final results on a closed poll and takes its
+// close off the schedule.  Once stored they are never overwritten, so
+// calling it again is a no-op
+func (lst *PollList) FreezeResults(id uint, results PollResults) error {
+	redisKey := lst.redisKeyFromId(int(id))
+	_, err := lst.jsonHelper.JSONSet(redisKey, ".finalResults", results, rjs.SetOptionNX)
+	if err != nil {
+		if isRedisNilError(err) {
+			return nil
+		}
+		return err
+	}
+
+	var poll Poll
+	if err := lst.getItemFromRedis(redisKey, &poll); err != nil {
+		return err
+	}
+	if err := lst.schedulePoll(poll); err != nil {
+		return err
+	}
+	lst.publishEvent(events.PollUpdated, poll.PollID, poll)
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode"
+
+	"drexel.edu/common/storage"
+)
+
+// Name of the RediSearch index over the poll documents
+const RedisSearchIndex = "poll-idx"
+
+var ErrEmptyQuery = storage.NewError(storage.ErrInvalid, "search query must contain at least one letter or digit")
+
+// Terms shorter than this are only prefix matched, fuzzy matching them
+// matches nearly everything
+const minFuzzyTermLength = 3
+
+// Helper to create the search index.  The index only needs to be created
+// once, so an index that already exists is not an error.  Stopwords are
+// turned off so words like "of" have to match here just as they do in the
+// memory and sqlite stores
+func (c *cache) ensureSearchIndex() error {
+	args := []interface{}{"FT.CREATE", c.key(RedisSearchIndex), "ON", "JSON",
+		"PREFIX", 1, c.key(RedisKeyPrefix), "STOPWORDS", 0, "SCHEMA",
+		"$.pollTitle", "AS", "title", "TEXT", "WEIGHT", 2,
+		"$.pollQuestion", "AS", "question", "TEXT",
+		"$.pollOptions[*].pollOptionText", "AS", "option", "TEXT",
+	}
+
+	err := c.cacheClient.Do(c.context, args...).Err()
+	if err != nil && strings.Contains(err.Error(), "Index already exists") {
+		return nil
+	}
+	return err
+}
+
+// Helper to split text into lower case words.  Anything other than
+// letters and digits only separates words
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Helper to turn free text into a RediSearch query.  Every word has to
+// match, either as the start of a word in the document or within one
+// typo of it.  Only letters and digits make it into the query, so
+// nothing needs escaping
+func searchQuery(text string) (string, error) {
+	words := searchWords(text)
+	if len(words) == 0 {
+		return "", ErrEmptyQuery
+	}
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		switch {
+		case len([]rune(word)) >= minFuzzyTermLength:
+			terms = append(terms, "("+word+"*|%"+word+"%)")
+		case len([]rune(word)) > 1:
+			terms = append(terms, word+"*")
+		default:
+			terms = append(terms, word)
+		}
+	}
+
+	return strings.Join(terms, " "), nil
+}
+
+// Helper that scores fields against query words the way searchQuery
+// matches them, for stores without RediSearch.  Every query word has to
+// match some word in the fields; a word that starts the field word scores
+// higher than one that is a typo away.  Zero means no match
+func searchScore(queryWords []string, fields ...string) int {
+	var fieldWords []string
+	for _, field := range fields {
+		fieldWords = append(fieldWords, searchWords(field)...)
+	}
+
+	score := 0
+	for _, word := range queryWords {
+		best := 0
+		for _, fieldWord := range fieldWords {
+			match := 0
+			switch {
+			case fieldWord == word:
+				match = 3
+			case len([]rune(word)) > 1 && strings.HasPrefix(fieldWord, word):
+				match = 2
+			case len([]rune(word)) >= minFuzzyTermLength && editDistance(fieldWord, word) <= 1:
+				match = 1
+			}
+			if match > best {
+				best = match
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		score += best
+	}
+
+	return score
+}
+
+// Helper to search polls that are already loaded, for stores without
+// RediSearch.  pollList is expected in id order, which is kept for ties
+func rankPolls(pollList []Poll, text string, limit int) ([]Poll, error) {
+	words := searchWords(text)
+	if len(words) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	scores := make(map[uint]int)
+	var matches []Poll
+	for _, poll := range pollList {
+		fields := []string{poll.PollTitle, poll.PollQuestion}
+		for _, option := range poll.PollOptions {
+			fields = append(fields, option.PollOptionText)
+		}
+		if score := searchScore(words, fields...); score > 0 {
+			scores[poll.PollID] = score
+			matches = append(matches, poll)
+		}
+	}
+
+	//Best match first, ties stay in id order
+	sort.SliceStable(matches, func(i, j int) bool {
+		return scores[matches[i].PollID] > scores[matches[j].PollID]
+	})
+	if limit = storage.PageLimit(limit); len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches, nil
+}
+
+// Helper to count the single letter edits needed to turn a into b
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+// Helper to run a search and return the matching documents, best match
+// first
+func (c *cache) searchDocuments(text string, limit int) ([]storage.Document, error) {
+	query, err := searchQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > storage.MaxPageLimit {
+		limit = storage.DefaultPageLimit
+	}
+
+	res, err := c.cacheClient.Do(c.context, "FT.SEARCH", c.key(RedisSearchIndex), query,
+		"RETURN", 1, "$", "LIMIT", 0, limit).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	//The reply is the number of matches followed by a key and a list of
+	//field/value pairs for each document
+	docs := make([]storage.Document, 0, len(res)/2)
+	for i := 2; i < len(res); i += 2 {
+		fields, ok := res[i].([]interface{})
+		if !ok || len(fields) < 2 {
+			continue
+		}
+		key, _ := res[i-1].(string)
+		if doc, ok := fields[1].(string); ok {
+			docs = append(docs, storage.Document{Key: key, JSON: []byte(doc)})
+		}
+	}
+
+	return docs, nil
+}
+
+// SearchPolls returns up to limit polls matching the text, best match first
+func (lst *PollList) SearchPolls(text string, limit int) ([]Poll, error) {
+	docs, err := lst.searchDocuments(text, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	pollList := make([]Poll, 0, len(docs))
+	for _, doc := range docs {
+		var poll Poll
+		if err := json.Unmarshal(doc.JSON, &poll); err != nil {
+			return nil, storage.CorruptRecord(doc.Key, err)
+		}
+		upgradePoll(&poll)
+		pollList = append(pollList, poll)
+	}
+
+	return pollList, nil
+}
@@ -0,0 +1,647 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"drexel.edu/common/storage"
+	_ "modernc.org/sqlite"
+)
+
+// DefaultSQLitePath is where the sqlite store keeps its database unless
+// told otherwise
+const DefaultSQLitePath = "polls.db"
+
+// Options and scheduled transitions belong to a poll and go with it when
+// the poll is deleted.  last_option_id remembers the highest option id
+// ever handed out, so retired or removed option ids are never reused.
+// Times are stored as RFC 3339 text, except due_at, which is unix seconds
+// so it compares like the redis schedule scores, and the lock expiry,
+// which is unix milliseconds
+const sqlitePollSchema = `
+CREATE TABLE IF NOT EXISTS polls (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	title          TEXT NOT NULL,
+	question       TEXT NOT NULL,
+	status         TEXT NOT NULL CHECK (status IN ('draft', 'open', 'closed')),
+	opens_at       TEXT,
+	closes_at      TEXT,
+	final_results  TEXT,
+	last_option_id INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS poll_options (
+	poll_id  INTEGER NOT NULL REFERENCES polls (id) ON DELETE CASCADE,
+	id       INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	text     TEXT NOT NULL,
+	retired  INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (poll_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS poll_transitions (
+	poll_id INTEGER NOT NULL REFERENCES polls (id) ON DELETE CASCADE,
+	action  TEXT NOT NULL CHECK (action IN ('open', 'close')),
+	due_at  INTEGER NOT NULL,
+	PRIMARY KEY (poll_id, action)
+);
+
+CREATE INDEX IF NOT EXISTS poll_transitions_due_at ON poll_transitions (due_at);
+
+CREATE TABLE IF NOT EXISTS scheduler_lock (
+	id         INTEGER PRIMARY KEY CHECK (id = 1),
+	token      TEXT NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+`
+
+// SQLitePollList keeps polls in a sqlite database file, so they survive
+// restarts without redis.  Replicas on the same host can share the file,
+// but no domain events are published and search is done in process
+type SQLitePollList struct {
+	db *sql.DB
+}
+
+// NewSQLitePollList opens, or creates, the database at path
+func NewSQLitePollList(path string) (*SQLitePollList, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(sqlitePollSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLitePollList{db: db}, nil
+}
+
+// Ping checks that the database file can still be used
+func (s *SQLitePollList) Ping() error {
+	return s.db.Ping()
+}
+
+// Close closes the database file
+func (s *SQLitePollList) Close() error {
+	return s.db.Close()
+}
+
+// WithContext returns the list itself, only redis commands are traced
+func (s *SQLitePollList) WithContext(ctx context.Context) PollStore {
+	return s
+}
+
+// Helper to open a sqlite database with foreign keys turned on.  Write
+// transactions take the write lock up front and wait for other writers,
+// instead of failing when two of them try to upgrade at once
+func openSQLite(path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_txlock=immediate" +
+		"&_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Helper to run fn in a transaction, committing if it succeeds
+func (s *SQLitePollList) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// querier is what the read helpers need, so they work both inside and
+// outside a transaction
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Helpers to store optional times as text
+func timeToSQL(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func timeFromSQL(s sql.NullString) (*time.Time, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s.String)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Helper to fill in the poll fields that are stored as text
+func readPollColumns(poll *Poll, opensAt, closesAt, finalResults sql.NullString) error {
+	var err error
+	if poll.OpensAt, err = timeFromSQL(opensAt); err != nil {
+		return err
+	}
+	if poll.ClosesAt, err = timeFromSQL(closesAt); err != nil {
+		return err
+	}
+	if finalResults.Valid {
+		poll.FinalResults = &PollResults{}
+		if err := json.Unmarshal([]byte(finalResults.String), poll.FinalResults); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Helper to read the polls a query selects, along with their options.
+// The query has to select from polls in id order
+func loadPolls(q querier, query string, args ...interface{}) ([]Poll, error) {
+	rows, err := q.Query(`SELECT id, title, question, status, opens_at, closes_at, final_results
+		FROM polls `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	pollList := []Poll{}
+	byId := make(map[uint]int)
+	for rows.Next() {
+		var poll Poll
+		var opensAt, closesAt, finalResults sql.NullString
+		if err := rows.Scan(&poll.PollID, &poll.PollTitle, &poll.PollQuestion, &poll.Status,
+			&opensAt, &closesAt, &finalResults); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err := readPollColumns(&poll, opensAt, closesAt, finalResults); err != nil {
+			rows.Close()
+			return nil, storage.CorruptRecord(fmt.Sprintf("poll %d", poll.PollID), err)
+		}
+		poll.PollOptions = []PollOption{}
+		byId[poll.PollID] = len(pollList)
+		pollList = append(pollList, poll)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(pollList) == 0 {
+		return pollList, nil
+	}
+
+	//The polls are in id order, so their options are all in this range
+	rows, err = q.Query(`SELECT poll_id, id, text, retired FROM poll_options
+		WHERE poll_id BETWEEN ? AND ? ORDER BY poll_id, position`,
+		pollList[0].PollID, pollList[len(pollList)-1].PollID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pollId uint
+		var option PollOption
+		if err := rows.Scan(&pollId, &option.PollOptionID, &option.PollOptionText, &option.Retired); err != nil {
+			return nil, err
+		}
+		if i, ok := byId[pollId]; ok {
+			pollList[i].PollOptions = append(pollList[i].PollOptions, option)
+		}
+	}
+
+	return pollList, rows.Err()
+}
+
+// Helper to read a single poll
+func loadPoll(q querier, id uint) (Poll, error) {
+	pollList, err := loadPolls(q, "WHERE id = ?", id)
+	if err != nil {
+		return Poll{}, err
+	}
+	if len(pollList) == 0 {
+		return Poll{}, ErrPollNotFound
+	}
+	return pollList[0], nil
+}
+
+// Helper to insert a new poll row.  With allocate set the poll takes the
+// next free id, otherwise it keeps its own
+func insertPollRow(tx *sql.Tx, poll *Poll, allocate bool) error {
+	var id interface{}
+	if !allocate {
+		id = poll.PollID
+	}
+
+	res, err := tx.Exec(`INSERT INTO polls (id, title, question, status, opens_at, closes_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		id, poll.PollTitle, poll.PollQuestion, poll.Status, timeToSQL(poll.OpensAt), timeToSQL(poll.ClosesAt))
+	if err != nil {
+		return err
+	}
+
+	if allocate {
+		newId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		poll.PollID = uint(newId)
+	}
+	return nil
+}
+
+// Helper to write everything about an existing poll row: its fields, its
+// options and its schedule.  Options without an id are given one first
+func writePoll(tx *sql.Tx, poll *Poll) error {
+	for i := range poll.PollOptions {
+		if poll.PollOptions[i].PollOptionID == 0 {
+			id, err := nextOptionIdTx(tx, *poll)
+			if err != nil {
+				return err
+			}
+			poll.PollOptions[i].PollOptionID = id
+		}
+	}
+
+	var finalResults interface{}
+	if poll.FinalResults != nil {
+		resultsJSON, err := json.Marshal(poll.FinalResults)
+		if err != nil {
+			return err
+		}
+		finalResults = string(resultsJSON)
+	}
+
+	_, err := tx.Exec(`UPDATE polls SET title = ?, question = ?, status = ?, opens_at = ?,
+		closes_at = ?, final_results = ?, last_option_id = MAX(last_option_id, ?) WHERE id = ?`,
+		poll.PollTitle, poll.PollQuestion, poll.Status, timeToSQL(poll.OpensAt),
+		timeToSQL(poll.ClosesAt), finalResults, highestOptionId(*poll), poll.PollID)
+	if err != nil {
+		return err
+	}
+
+	//Options are few, so it is simplest to write them out again
+	if _, err := tx.Exec(`DELETE FROM poll_options WHERE poll_id = ?`, poll.PollID); err != nil {
+		return err
+	}
+	for position, option := range poll.PollOptions {
+		_, err := tx.Exec(`INSERT INTO poll_options (poll_id, id, position, text, retired)
+			VALUES (?, ?, ?, ?, ?)`,
+			poll.PollID, option.PollOptionID, position, option.PollOptionText, option.Retired)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM poll_transitions WHERE poll_id = ?`, poll.PollID); err != nil {
+		return err
+	}
+	for transition, at := range pollTransitions(*poll) {
+		_, err := tx.Exec(`INSERT INTO poll_transitions (poll_id, action, due_at) VALUES (?, ?, ?)`,
+			transition.PollID, transition.Action, at.Unix())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Helper to allocate a new option id for a poll that is already stored
+func nextOptionIdTx(tx *sql.Tx, poll Poll) (uint, error) {
+	var id uint
+	err := tx.QueryRow(`UPDATE polls SET last_option_id = MAX(last_option_id, ?) + 1
+		WHERE id = ? RETURNING last_option_id`, highestOptionId(poll), poll.PollID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrPollNotFound
+	}
+	return id, err
+}
+
+// Helper to check whether a poll is stored
+func pollExistsTx(tx *sql.Tx, id uint) (bool, error) {
+	var n int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM polls WHERE id = ?`, id).Scan(&n)
+	return n > 0, err
+}
+
+func (s *SQLitePollList) AddPoll(poll *Poll) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		exists, err := pollExistsTx(tx, poll.PollID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrPollExists
+		}
+		return s.addPoll(tx, poll, false)
+	})
+}
+
+// CreatePoll adds a poll under the next free id and writes that id back
+// into the poll.  sqlite remembers the highest id it has seen, including
+// ids chosen by clients, so the id is always free
+func (s *SQLitePollList) CreatePoll(poll *Poll) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		return s.addPoll(tx, poll, true)
+	})
+}
+
+// Helper that does the work of AddPoll and CreatePoll
+func (s *SQLitePollList) addPoll(tx *sql.Tx, poll *Poll, allocate bool) error {
+	if err := prepareNewPoll(poll); err != nil {
+		return err
+	}
+	if err := insertPollRow(tx, poll, allocate); err != nil {
+		return err
+	}
+	return writePoll(tx, poll)
+}
+
+func (s *SQLitePollList) UpdatePoll(poll *Poll) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		existing, err := loadPoll(tx, poll.PollID)
+		if err != nil {
+			return err
+		}
+		if err := prepareUpdatedPoll(existing, poll); err != nil {
+			return err
+		}
+		return writePoll(tx, poll)
+	})
+}
+
+func (s *SQLitePollList) DeletePoll(id uint) error {
+	//The options and schedule go with the poll
+	res, err := s.db.Exec(`DELETE FROM polls WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrPollNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLitePollList) DeleteAll() error {
+	_, err := s.db.Exec(`DELETE FROM polls`)
+	return err
+}
+
+func (s *SQLitePollList) GetSinglePollResource(id uint) (Poll, error) {
+	return loadPoll(s.db, id)
+}
+
+func (s *SQLitePollList) GetAllPolls() ([]Poll, error) {
+	return loadPolls(s.db, "ORDER BY id")
+}
+
+func (s *SQLitePollList) GetPollsPage(cursor string, limit int) ([]Poll, string, error) {
+	limit = storage.PageLimit(limit)
+
+	var after uint
+	if cursor != "" {
+		var err error
+		if after, err = storage.ParseCursor(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	//Read one poll past the page to find out whether there is another
+	pollList, err := loadPolls(s.db, "WHERE id > ? ORDER BY id LIMIT ?", after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(pollList) <= limit {
+		return pollList, "", nil
+	}
+
+	ids := make([]uint, len(pollList))
+	for i, poll := range pollList {
+		ids[i] = poll.PollID
+	}
+	_, next, err := storage.TrimPage(ids, limit)
+	return pollList[:limit], next, err
+}
+
+func (s *SQLitePollList) SearchPolls(text string, limit int) ([]Poll, error) {
+	if len(searchWords(text)) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	pollList, err := s.GetAllPolls()
+	if err != nil {
+		return nil, err
+	}
+	return rankPolls(pollList, text, limit)
+}
+
+// Helper to change a stored poll with modify inside a transaction.
+// Closed polls can't be changed
+func (s *SQLitePollList) modifyPoll(id uint, modify func(tx *sql.Tx, poll *Poll) error) (Poll, error) {
+	var poll Poll
+	err := s.inTx(func(tx *sql.Tx) error {
+		var err error
+		if poll, err = loadPoll(tx, id); err != nil {
+			return err
+		}
+		if poll.Status == PollStatusClosed {
+			return ErrPollClosed
+		}
+		if err := modify(tx, &poll); err != nil {
+			return err
+		}
+		return writePoll(tx, &poll)
+	})
+	if err != nil {
+		return Poll{}, err
+	}
+
+	return poll, nil
+}
+
+func (s *SQLitePollList) OpenPoll(id uint) (Poll, error) {
+	return s.changeStatus(id, openPollAt)
+}
+
+func (s *SQLitePollList) ClosePoll(id uint) (Poll, error) {
+	return s.changeStatus(id, closePollAt)
+}
+
+// Helper shared by OpenPoll and ClosePoll
+func (s *SQLitePollList) changeStatus(id uint, change func(*Poll, time.Time) error) (Poll, error) {
+	var poll Poll
+	err := s.inTx(func(tx *sql.Tx) error {
+		var err error
+		if poll, err = loadPoll(tx, id); err != nil {
+			return err
+		}
+		if err := change(&poll, time.Now().UTC()); err != nil {
+			return err
+		}
+		return writePoll(tx, &poll)
+	})
+	if err != nil {
+		return Poll{}, err
+	}
+
+	return poll, nil
+}
+
+func (s *SQLitePollList) FreezeResults(id uint, results PollResults) error {
+	resultsJSON, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(func(tx *sql.Tx) error {
+		exists, err := pollExistsTx(tx, id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrPollNotFound
+		}
+
+		//Results that are already frozen are left alone
+		_, err = tx.Exec(`UPDATE polls SET final_results = ? WHERE id = ? AND final_results IS NULL`,
+			string(resultsJSON), id)
+		if err != nil {
+			return err
+		}
+
+		//With the results recorded there is nothing left to close
+		_, err = tx.Exec(`DELETE FROM poll_transitions WHERE poll_id = ? AND action = ?`,
+			id, TransitionClose)
+		return err
+	})
+}
+
+func (s *SQLitePollList) AddPollOption(pollId uint, text string) (PollOption, error) {
+	if text == "" {
+		return PollOption{}, ErrEmptyOptionText
+	}
+
+	var option PollOption
+	_, err := s.modifyPoll(pollId, func(tx *sql.Tx, poll *Poll) error {
+		id, err := nextOptionIdTx(tx, *poll)
+		if err != nil {
+			return err
+		}
+		option = PollOption{PollOptionID: id, PollOptionText: text}
+		poll.PollOptions = append(poll.PollOptions, option)
+		return nil
+	})
+	if err != nil {
+		return PollOption{}, err
+	}
+
+	return option, nil
+}
+
+func (s *SQLitePollList) RenamePollOption(pollId uint, optionId uint, text string) (PollOption, error) {
+	if text == "" {
+		return PollOption{}, ErrEmptyOptionText
+	}
+
+	var renamed PollOption
+	_, err := s.modifyPoll(pollId, func(tx *sql.Tx, poll *Poll) error {
+		var err error
+		renamed, err = renameOption(poll, optionId, text)
+		return err
+	})
+	if err != nil {
+		return PollOption{}, err
+	}
+
+	return renamed, nil
+}
+
+func (s *SQLitePollList) RetirePollOption(pollId uint, optionId uint) (PollOption, error) {
+	var retired PollOption
+	_, err := s.modifyPoll(pollId, func(tx *sql.Tx, poll *Poll) error {
+		var err error
+		retired, err = retireOption(poll, optionId)
+		return err
+	})
+	if err != nil {
+		return PollOption{}, err
+	}
+
+	return retired, nil
+}
+
+func (s *SQLitePollList) ReorderPollOptions(pollId uint, order []uint) ([]PollOption, error) {
+	poll, err := s.modifyPoll(pollId, func(tx *sql.Tx, poll *Poll) error {
+		return reorderOptions(poll, order)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return poll.PollOptions, nil
+}
+
+func (s *SQLitePollList) DueTransitions(now time.Time) ([]Transition, error) {
+	rows, err := s.db.Query(`SELECT poll_id, action FROM poll_transitions
+		WHERE due_at <= ? ORDER BY due_at`, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transitions []Transition
+	for rows.Next() {
+		var transition Transition
+		if err := rows.Scan(&transition.PollID, &transition.Action); err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, transition)
+	}
+
+	return transitions, rows.Err()
+}
+
+func (s *SQLitePollList) CompleteTransition(t Transition) error {
+	_, err := s.db.Exec(`DELETE FROM poll_transitions WHERE poll_id = ? AND action = ?`,
+		t.PollID, t.Action)
+	return err
+}
+
+func (s *SQLitePollList) AcquireSchedulerLock(token string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+
+	//Take the lock if nobody holds it or the holder's lock has expired
+	res, err := s.db.Exec(`INSERT INTO scheduler_lock (id, token, expires_at) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET token = excluded.token, expires_at = excluded.expires_at
+		WHERE scheduler_lock.expires_at <= ?`,
+		token, now.Add(ttl).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLitePollList) ReleaseSchedulerLock(token string) error {
+	_, err := s.db.Exec(`DELETE FROM scheduler_lock WHERE id = 1 AND token = ?`, token)
+	return err
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"drexel.edu/common/config"
+)
+
+// The stores NewStore knows how to build
+const (
+	StoreRedis  = config.StoreRedis
+	StoreMemory = config.StoreMemory
+	StoreSQLite = config.StoreSQLite
+)
+
+// PollStore is everything the API needs from the place polls are kept.
+// PollList keeps them in redis, MemoryPollList keeps them in process
+// memory and SQLitePollList keeps them in a sqlite file
+type PollStore interface {
+	AddPoll(poll *Poll) error
+	CreatePoll(poll *Poll) error
+	UpdatePoll(poll *Poll) error
+	DeletePoll(id uint) error
+	DeleteAll() error
+	GetSinglePollResource(id uint) (Poll, error)
+	GetAllPolls() ([]Poll, error)
+	GetPollsPage(cursor string, limit int) ([]Poll, string, error)
+	SearchPolls(text string, limit int) ([]Poll, error)
+
+	OpenPoll(id uint) (Poll, error)
+	ClosePoll(id uint) (Poll, error)
+	FreezeResults(id uint, results PollResults) error
+
+	AddPollOption(pollId uint, text string) (PollOption, error)
+	RenamePollOption(pollId uint, optionId uint, text string) (PollOption, error)
+	RetirePollOption(pollId uint, optionId uint) (PollOption, error)
+	ReorderPollOptions(pollId uint, order []uint) ([]PollOption, error)
+
+	DueTransitions(now time.Time) ([]Transition, error)
+	CompleteTransition(t Transition) error
+	AcquireSchedulerLock(token string, ttl time.Duration) (bool, error)
+	ReleaseSchedulerLock(token string) error
+
+	Ping() error
+	Close() error
+	WithContext(ctx context.Context) PollStore
+}
+
+var (
+	_ PollStore = (*PollList)(nil)
+	_ PollStore = (*MemoryPollList)(nil)
+	_ PollStore = (*SQLitePollList)(nil)
+)
+
+// NewStore builds the store named by kind, one of StoreRedis, StoreMemory
+// or StoreSQLite.  redisCfg is only used by StoreRedis, sqlitePath is the
+// database file and is only used by StoreSQLite
+func NewStore(kind string, redisCfg RedisConfig, sqlitePath string) (PollStore, error) {
+	switch kind {
+	case StoreRedis:
+		return NewWithConfig(redisCfg)
+	case StoreMemory:
+		return NewMemoryPollList(), nil
+	case StoreSQLite:
+		return NewSQLitePollList(sqlitePath)
+	}
+	return nil, fmt.Errorf("unknown store %q, use %s, %s or %s", kind, StoreRedis, StoreMemory, StoreSQLite)
+}
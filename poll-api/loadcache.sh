@@ -1,4 +1,4 @@
 #!/bin/bash
-curl -d '{ "pollId": 1, "pollTitle": "Favorite Pet", "pollQuestion": "What type of pet do you like best?", "pollOptions": [{"pollOptionId": 1, "pollOptionText": "Dog"}, {"pollOptionId": 2, "pollOptionText": "Cat"}, {"pollOptionId": 3, "pollOptionText": "Fish"}, {"pollOptionId": 4, "pollOptionText": "Bird"}, {"pollOptionId": 5, "pollOptionText": "NONE"}] }' -H "Content-Type: application/json" -X POST http://localhost:1080/polls/1
-curl -d '{ "pollId": 2, "pollTitle": "Favorite Color", "pollQuestion": "What is your favorite color?", "pollOptions": [{"pollOptionId": 1, "pollOptionText": "Red"}, {"pollOptionId": 2, "pollOptionText": "Blue"}, {"pollOptionId": 3, "pollOptionText": "Green"}, {"pollOptionId": 4, "pollOptionText": "Black"}, {"pollOptionId": 5, "pollOptionText": "White"}] }' -H "Content-Type: application/json" -X POST http://localhost:1080/polls/2
-curl -d '{ "pollId": 3, "pollTitle": "CS-T680", "pollQuestion": "Do you like this class?", "pollOptions": [{"pollOptionId": 1, "pollOptionText": "Yes"}, {"pollOptionId": 2, "pollOptionText": "No"}, {"pollOptionId": 3, "pollOptionText": "Mixed"}] }' -H "Content-Type: application/json" -X POST http://localhost:1080/polls/3
\ No newline at end of file
+curl -d '{ "pollId": 1, "pollTitle": "Favorite Pet", "pollQuestion": "What type of pet do you like best?", "status": "open", "pollOptions": [{"pollOptionId": 1, "pollOptionText": "Dog"}, {"pollOptionId": 2, "pollOptionText": "Cat"}, {"pollOptionId": 3, "pollOptionText": "Fish"}, {"pollOptionId": 4, "pollOptionText": "Bird"}, {"pollOptionId": 5, "pollOptionText": "NONE"}] }' -H "Content-Type: application/json" -X POST http://localhost:1080/polls/1
+curl -d '{ "pollId": 2, "pollTitle": "Favorite Color", "pollQuestion": "What is your favorite color?", "status": "open", "pollOptions": [{"pollOptionId": 1, "pollOptionText": "Red"}, {"pollOptionId": 2, "pollOptionText": "Blue"}, {"pollOptionId": 3, "pollOptionText": "Green"}, {"pollOptionId": 4, "pollOptionText": "Black"}, {"pollOptionId": 5, "pollOptionText": "White"}] }' -H "Content-Type: application/json" -X POST http://localhost:1080/polls/2
+curl -d '{ "pollId": 3, "pollTitle": "CS-T680", "pollQuestion": "Do you like this class?", "status": "open", "pollOptions": [{"pollOptionId": 1, "pollOptionText": "Yes"}, {"pollOptionId": 2, "pollOptionText": "No"}, {"pollOptionId": 3, "pollOptionText": "Mixed"}] }' -H "Content-Type: application/json" -X POST http://localhost:1080/polls/3
\ No newline at end of file
@@ -1,83 +1,199 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"drexel.edu/common/config"
+	"drexel.edu/common/logging"
+	"drexel.edu/common/tracing"
+	"drexel.edu/common/web"
 	"drexel.edu/poll-api/api"
+	"drexel.edu/poll-api/db"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-// Global variables to hold the command line flags to drive the todo CLI
-// application
-var (
-	hostFlag string
-	portFlag uint
-)
-
-// processCmdLineFlags parses the command line flags for our CLI
-//
-// TODO: This function uses the flag package to parse the command line
-//		 flags.  The flag package is not very flexible and can lead to
-//		 some confusing code.
-
-//			 REQUIRED:     Study the code below, and make sure you understand
-//						   how it works.  Go online and readup on how the
-//						   flag package works.  Then, write a nice comment
-//				  		   block to document this function that highights that
-//						   you understand how it works.
-//
-//			 EXTRA CREDIT: The best CLI and command line processor for
-//						   go is called Cobra.  Refactor this function to
-//						   use it.  See github.com/spf13/cobra for information
-//						   on how to use it.
-//
-//	 YOUR ANSWER: <GOES HERE>
-func processCmdLineFlags() {
-
-	//Note some networking lingo, some frameworks start the server on localhost
-	//this is a local-only interface and is fine for testing but its not accessible
-	//from other machines.  To make the server accessible from other machines, we
-	//need to listen on an interface, that could be an IP address, but modern
-	//cloud servers may have multiple network interfaces for scale.  With TCP/IP
-	//the address 0.0.0.0 instructs the network stack to listen on all interfaces
-	//We set this up as a flag so that we can overwrite it on the command line if
-	//needed
-	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
-	flag.UintVar(&portFlag, "p", 1080, "Default Port")
-
-	flag.Parse()
+// What sets this service's configuration apart from the others
+var service = config.Service{
+	Keeps:      "polls",
+	SQLitePath: db.DefaultSQLitePath,
+	Calls: map[string]string{
+		config.VotesAPI: "http://localhost:1082",
+		config.VoterAPI: "http://localhost:1081",
+	},
 }
 
 // main is the entry point for our todo API application.  It processes
 // the command line flags and then uses the db package to perform the
 // requested operation
 func main() {
-	processCmdLineFlags()
-	r := gin.Default()
+	//Settings come from the defaults, an optional config file, the
+	//environment and the command line, in that order
+	cfg, err := config.Load(service, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := logging.Setup(cfg.LogFormat, cfg.LogLevel); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if cfg.File != "" {
+		slog.Info("Init", "configFile", cfg.File)
+	}
+	slog.Info("Init", "votesAPIURL", cfg.VotesAPIURL)
+	slog.Info("Init", "voterAPIURL", cfg.VoterAPIURL)
+	slog.Info("Init", "store", cfg.Store)
+	switch cfg.Store {
+	case db.StoreRedis:
+		slog.Info("Init", "redis", cfg.Redis.Addr, "redisDB", cfg.Redis.DB, "redisTLS", cfg.Redis.TLS, "redisKeyPrefix", cfg.Redis.KeyPrefix)
+	case db.StoreSQLite:
+		slog.Info("Init", "sqlitePath", cfg.SQLitePath)
+	}
+	slog.Info("Init", "traces", cfg.Traces)
+
+	//gin's own request logger is replaced by AccessLog, which logs in the
+	//same format as everything else
+	r := gin.New()
+	r.Use(gin.Recovery())
 	r.Use(cors.Default())
+	r.Use(api.Tracing())
+	r.Use(web.RequestID())
+	r.Use(web.AccessLog())
+	r.Use(api.Metrics())
 
-	apiHandler, err := api.New()
+	shutdownTracing, err := tracing.Setup(api.ServiceName, cfg.Traces, cfg.TracesFile)
+	if err != nil {
+		panic(err)
+	}
+
+	//Stop on SIGINT from a terminal or SIGTERM from docker
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	apiHandler, err := api.New(cfg.Store, cfg.Redis, cfg.SQLitePath, cfg.VotesAPIURL, cfg.VoterAPIURL)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	r.Use(apiHandler.CountRequests())
+
+	//Open and close polls when their scheduled times arrive.  The
+	//scheduler stops with the server, after finishing any transition it
+	//has started
+	schedulerDone := make(chan struct{})
+	go func() {
+		apiHandler.RunScheduler(ctx, time.Second)
+		close(schedulerDone)
+	}()
 
 	r.GET("/polls", apiHandler.GetAllPollResources)
 
+	r.GET("/polls/search", apiHandler.SearchPolls)
 	r.GET("/polls/:id", apiHandler.GetSinglePollResource)
+	// Create a poll under a server assigned id
+	r.POST("/polls", apiHandler.CreatePoll)
 	// Create a voters resource with id = :id, initialize the polls slice to an
 	// empty slice
 	r.POST("/polls/:id", apiHandler.AddPoll)
 
+	// Replace a poll, or update part of it with a JSON merge patch
+	r.PUT("/polls/:id", apiHandler.UpdatePoll)
+	r.PATCH("/polls/:id", apiHandler.PatchPoll)
+
+	// Manage individual options, ids are allocated by the server and
+	// deleting an option retires it
+	r.GET("/polls/:id/options", apiHandler.GetPollOptions)
+	r.POST("/polls/:id/options", apiHandler.AddPollOption)
+	r.PUT("/polls/:id/options/order", apiHandler.ReorderPollOptions)
+	r.PATCH("/polls/:id/options/:optionid", apiHandler.RenamePollOption)
+	r.DELETE("/polls/:id/options/:optionid", apiHandler.RetirePollOption)
+
+	// Move a poll through its lifecycle, draft -> open -> closed
+	r.POST("/polls/:id/open", apiHandler.OpenPoll)
+	r.POST("/polls/:id/close", apiHandler.ClosePoll)
+
+	// Liveness and readiness checks, /polls/health is kept for older
+	// clients
+	r.GET("/healthz", apiHandler.HealthCheck)
+	r.GET("/readyz", apiHandler.ReadinessCheck)
 	r.GET("/polls/health", apiHandler.HealthCheck)
 
+	// Prometheus metrics
+	r.GET("/metrics", api.MetricsHandler())
+
 	r.DELETE("/polls", apiHandler.DeleteAllPolls)
 
 	r.DELETE("/polls/:id", apiHandler.DeletePoll)
 
-	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
-	r.Run(serverPath)
+	serverPath := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	srv := &http.Server{
+		Addr:         serverPath,
+		Handler:      r,
+		ReadTimeout:  cfg.Timeouts.Read,
+		WriteTimeout: cfg.Timeouts.Write,
+		IdleTimeout:  cfg.Timeouts.Idle,
+	}
+
+	err = serve(ctx, srv, cfg.Timeouts.Shutdown)
+	if err != nil {
+		slog.Error("Error running server", "error", err)
+	}
+	stop()
+
+	//Only release the store and flush traces once nothing can use them
+	<-schedulerDone
+	if err := apiHandler.Close(); err != nil {
+		slog.Error("Error closing store", "error", err)
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// serve runs srv until ctx is cancelled, then stops taking new
+// connections and gives the requests in flight up to shutdownTimeout to
+// finish
+func serve(ctx context.Context, srv *http.Server, shutdownTimeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down, draining requests", "timeout", shutdownTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Requests are labelled with the route they matched, like /voters/:id,
+// rather than the path, so there is one series per route instead of one
+// per voter
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests, by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	downstreamRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "downstream_request_duration_seconds",
+		Help:    "Time taken by calls to other services, by host, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"host", "method", "status"})
+
+	downstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "downstream_errors_total",
+		Help: "Calls to other services that failed to connect or got a 5xx, by host and method.",
+	}, []string{"host", "method"})
+)
+
+// Business counters
+var (
+	votersCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "voters_created_total",
+		Help: "Voters created.",
+	})
+
+	votersDeleted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "voters_deleted_total",
+		Help: "Voters deleted.",
+	})
+)
+
+// Metrics is middleware that counts and times every request
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+		httpRequestDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// MetricsHandler serves every metric in the Prometheus text format, for
+// GET /metrics
+func MetricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// Helper to time the calls a resty client makes to other services and
+// count the ones that fail
+func instrumentClient(client *resty.Client) *resty.Client {
+	client.OnAfterResponse(func(_ *resty.Client, resp *resty.Response) error {
+		req := resp.Request
+		host := hostOf(req.URL)
+		downstreamRequestDuration.WithLabelValues(host, req.Method, strconv.Itoa(resp.StatusCode())).
+			Observe(resp.Time().Seconds())
+		if resp.StatusCode() >= 500 {
+			downstreamErrors.WithLabelValues(host, req.Method).Inc()
+		}
+		return nil
+	})
+	client.OnError(func(req *resty.Request, err error) {
+		host := hostOf(req.URL)
+		//The request may have failed before it was sent
+		if !req.Time.IsZero() {
+			downstreamRequestDuration.WithLabelValues(host, req.Method, "error").
+				Observe(time.Since(req.Time).Seconds())
+		}
+		downstreamErrors.WithLabelValues(host, req.Method).Inc()
+	})
+	return client
+}
+
+// Helper to pull the host out of a request URL for a metric label
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "unknown"
+	}
+	return u.Host
+}
@@ -1,27 +1,49 @@
 package api
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
 
+	"drexel.edu/common/storage"
+	"drexel.edu/common/web"
 	"drexel.edu/voter-api/db"
 	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
 )
 
 // The api package creates and maintains a reference to the data handler
 // this is a good design practice
 type VoterAPI struct {
-	db *db.VoterList
+	db          db.VoterStore
+	votesAPIURL string
+	apiClient   *resty.Client
+	storeKind   string
+	health      *healthStats
 }
 
-func New() (*VoterAPI, error) {
-	dbHandler, err := db.NewVoterList()
+func New(store string, redisCfg db.RedisConfig, sqlitePath string, votesAPIURL string) (*VoterAPI, error) {
+	dbHandler, err := db.NewStore(store, redisCfg, sqlitePath)
 	if err != nil {
 		return nil, err
 	}
 
-	return &VoterAPI{db: dbHandler}, nil
+	return &VoterAPI{
+		db:          dbHandler,
+		votesAPIURL: votesAPIURL,
+		apiClient:   instrumentClient(traceClient(web.ForwardRequestID(resty.New()))),
+		storeKind:   store,
+		health:      newHealthStats(),
+	}, nil
+}
+
+// Close releases the store.  Call it once the server has stopped handling
+// requests
+func (v *VoterAPI) Close() error {
+	return v.db.Close()
 }
 
 //Below we implement the API functions.  Some of the framework
@@ -38,11 +60,26 @@ func New() (*VoterAPI, error) {
 // implementation for GET /todo
 // returns all todos
 func (v *VoterAPI) GetAllVoterResources(c *gin.Context) {
+	cursor, limit, paged, ok := web.ParsePage(c)
+	if !ok {
+		return
+	}
 
-	voterList, err := v.db.GetAllVoters()
+	if paged {
+		voterList, next, err := v.store(c).GetVotersPage(cursor, limit)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error Getting Voters Page", "error", err)
+			web.AbortWithStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, web.PageResponse{Items: voterList, NextCursor: next})
+		return
+	}
+
+	voterList, err := v.store(c).GetAllVoters()
 	if err != nil {
-		log.Println("Error Getting All Voters: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
+		slog.ErrorContext(c.Request.Context(), "Error Getting All Voters", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
 	//Note that the database returns a nil slice if there are no items
@@ -65,22 +102,22 @@ func (v *VoterAPI) GetSingleVoterResource(c *gin.Context) {
 	//convert it to an int64 using the strconv package
 	idS := c.Param("id")
 	if idS == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No voter ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No voter ID provided")
 		return
 	}
 	id64, err := strconv.ParseInt(idS, 10, 32)
 	if err != nil {
-		log.Println("Error converting id to int64: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		slog.WarnContext(c.Request.Context(), "Error converting id to int64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid voter ID")
 		return
 	}
 
 	//Note that ParseInt always returns an int64, so we have to
 	//convert it to an int before we can use it.
-	voter, err := v.db.GetSingleVoterResource(uint(id64))
+	voter, err := v.store(c).GetSingleVoterResource(uint(id64))
 	if err != nil {
-		log.Println("Item not found: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
+		slog.WarnContext(c.Request.Context(), "Item not found", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
 
@@ -96,22 +133,22 @@ func (v *VoterAPI) GetVoterHistory(c *gin.Context) {
 	//convert it to an int64 using the strconv package
 	idS := c.Param("id")
 	if idS == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No voter ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No voter ID provided")
 		return
 	}
 	id64, err := strconv.ParseInt(idS, 10, 32)
 	if err != nil {
-		log.Println("Error converting id to int64: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		slog.WarnContext(c.Request.Context(), "Error converting id to int64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid voter ID")
 		return
 	}
 
 	//Note that ParseInt always returns an int64, so we have to
 	//convert it to an int before we can use it.
-	voter, err := v.db.GetVoterHistory(uint(id64))
+	voter, err := v.store(c).GetVoterHistory(uint(id64))
 	if err != nil {
-		log.Println("Item not found: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
+		slog.WarnContext(c.Request.Context(), "Item not found", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
 
@@ -127,34 +164,34 @@ func (v *VoterAPI) GetVoterPollData(c *gin.Context) {
 	//convert it to an int64 using the strconv package
 	idS := c.Param("id")
 	if idS == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No voter ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No voter ID provided")
 		return
 	}
 	idP := c.Param("pollid")
 	if idS == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No poll ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No poll ID provided")
 		return
 	}
 	id64_1, err_1 := strconv.ParseInt(idS, 10, 32)
 	id64_2, err_2 := strconv.ParseInt(idP, 10, 32)
 	if err_1 != nil {
-		log.Println("Error converting voterid to int64: ", err_1)
-		c.AbortWithStatus(http.StatusBadRequest)
+		slog.WarnContext(c.Request.Context(), "Error converting voterid to int64", "error", err_1)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid voter ID")
 		return
 	}
 
 	if err_2 != nil {
-		log.Println("Error converting pollid to int64: ", err_2)
-		c.AbortWithStatus(http.StatusBadRequest)
+		slog.WarnContext(c.Request.Context(), "Error converting pollid to int64", "error", err_2)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid poll ID")
 		return
 	}
 
 	//Note that ParseInt always returns an int64, so we have to
 	//convert it to an int before we can use it.
-	voter, err := v.db.GetVoterPollData(uint(id64_1), uint(id64_2))
+	voter, err := v.store(c).GetVoterPollData(uint(id64_1), uint(id64_2))
 	if err != nil {
-		log.Println("Item not found: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
+		slog.WarnContext(c.Request.Context(), "Item not found", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
 
@@ -170,34 +207,34 @@ func (v *VoterAPI) AddVoterPollData(c *gin.Context) {
 	//convert it to an int64 using the strconv package
 	idS := c.Param("id")
 	if idS == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No voter ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No voter ID provided")
 		return
 	}
 	idP := c.Param("pollid")
 	if idS == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No poll ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No poll ID provided")
 		return
 	}
 	id64_1, err_1 := strconv.ParseInt(idS, 10, 32)
 	id64_2, err_2 := strconv.ParseInt(idP, 10, 32)
 	if err_1 != nil {
-		log.Println("Error converting voterid to int64: ", err_1)
-		c.AbortWithStatus(http.StatusBadRequest)
+		slog.WarnContext(c.Request.Context(), "Error converting voterid to int64", "error", err_1)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid voter ID")
 		return
 	}
 
 	if err_2 != nil {
-		log.Println("Error converting voterid to int64: ", err_2)
-		c.AbortWithStatus(http.StatusBadRequest)
+		slog.WarnContext(c.Request.Context(), "Error converting pollid to int64", "error", err_2)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid poll ID")
 		return
 	}
 
 	//Note that ParseInt always returns an int64, so we have to
 	//convert it to an int before we can use it.
-	err2 := v.db.AddVoterPollData(uint(id64_1), uint(id64_2))
+	err2 := v.store(c).AddVoterPollData(uint(id64_1), uint(id64_2))
 	if err2 != nil {
-		log.Println("Item not found: ", err_1)
-		c.AbortWithStatus(http.StatusNotFound)
+		slog.WarnContext(c.Request.Context(), "Item not found", "error", err2)
+		web.AbortWithStoreError(c, err2)
 		return
 	}
 }
@@ -209,34 +246,34 @@ func (v *VoterAPI) DeletePoll(c *gin.Context) {
 	//convert it to an int64 using the strconv package
 	idS := c.Param("id")
 	if idS == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No voter ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No voter ID provided")
 		return
 	}
 	idP := c.Param("pollid")
 	if idS == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No poll ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No poll ID provided")
 		return
 	}
 	id64_1, err_1 := strconv.ParseInt(idS, 10, 32)
 	id64_2, err_2 := strconv.ParseInt(idP, 10, 32)
 	if err_1 != nil {
-		log.Println("Error converting voterid to int64: ", err_1)
-		c.AbortWithStatus(http.StatusBadRequest)
+		slog.WarnContext(c.Request.Context(), "Error converting voterid to int64", "error", err_1)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid voter ID")
 		return
 	}
 
 	if err_2 != nil {
-		log.Println("Error converting voterid to int64: ", err_2)
-		c.AbortWithStatus(http.StatusBadRequest)
+		slog.WarnContext(c.Request.Context(), "Error converting pollid to int64", "error", err_2)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid poll ID")
 		return
 	}
 
 	//Note that ParseInt always returns an int64, so we have to
 	//convert it to an int before we can use it.
-	err2 := v.db.DeletePoll(uint(id64_1), uint(id64_2))
+	err2 := v.store(c).DeletePoll(uint(id64_1), uint(id64_2))
 	if err2 != nil {
-		log.Println("Item not found: ", err_1)
-		c.AbortWithStatus(http.StatusNotFound)
+		slog.WarnContext(c.Request.Context(), "Item not found", "error", err2)
+		web.AbortWithStoreError(c, err2)
 		return
 	}
 }
@@ -244,6 +281,14 @@ func (v *VoterAPI) DeletePoll(c *gin.Context) {
 // implementation for POST /todo
 // adds a new todo
 func (v *VoterAPI) AddVoter(c *gin.Context) {
+	idS := c.Param("id")
+	id64, err := strconv.ParseInt(idS, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to int64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid voter ID")
+		return
+	}
+
 	var voter db.Voter
 
 	//With HTTP based APIs, a POST request will usually
@@ -259,33 +304,67 @@ func (v *VoterAPI) AddVoter(c *gin.Context) {
 	//the struct we are binding to.
 
 	if err := c.ShouldBindJSON(&voter); err != nil {
-		log.Println("Error binding JSON: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		slog.WarnContext(c.Request.Context(), "Error binding JSON", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	//The body may leave out the id, but it can't name a different voter
+	if voter.VoterId == 0 {
+		voter.VoterId = uint(id64)
+	}
+	if voter.VoterId != uint(id64) {
+		web.AbortWithError(c, http.StatusBadRequest, "Voter ID in body does not match the URL")
 		return
 	}
 
-	if err := v.db.AddVoter(voter); err != nil {
-		log.Println("Error adding item: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	if err := v.store(c).AddVoter(voter); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error adding item", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
+	votersCreated.Inc()
 
 	c.JSON(http.StatusOK, voter)
 }
 
-// implementation for PUT /todo
-// Web api standards use PUT for Updates
+// implementation for POST /voters
+// the server picks the voter id and returns it in the Location header
+func (v *VoterAPI) CreateVoter(c *gin.Context) {
+	var voter db.Voter
+	if err := c.ShouldBindJSON(&voter); err != nil {
+		slog.WarnContext(c.Request.Context(), "Error binding JSON", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	if voter.VoterId != 0 {
+		web.AbortWithError(c, http.StatusBadRequest, "Voter IDs are assigned by the server, use POST /voters/:id to choose one")
+		return
+	}
+
+	if err := v.store(c).CreateVoter(&voter); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error creating item", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+	votersCreated.Inc()
+
+	c.Header("Location", fmt.Sprintf("/voters/%d", voter.VoterId))
+	c.JSON(http.StatusCreated, voter)
+}
+
 func (v *VoterAPI) UpdateVoter(c *gin.Context) {
 	var voter db.Voter
 	if err := c.ShouldBindJSON(&voter); err != nil {
-		log.Println("Error binding JSON: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		slog.WarnContext(c.Request.Context(), "Error binding JSON", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
 		return
 	}
 
-	if err := v.db.UpdateVoter(voter); err != nil {
-		log.Println("Error updating item: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	if err := v.store(c).UpdateVoter(voter); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error updating item", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
 
@@ -293,38 +372,107 @@ func (v *VoterAPI) UpdateVoter(c *gin.Context) {
 }
 
 // implementation for DELETE /todo/:id
-// deletes a todo
+// deletes a todo.  With ?cascade=true the votes the voter cast are also
+// deleted from the votes API
 func (v *VoterAPI) DeleteVoter(c *gin.Context) {
 	idS := c.Param("id")
 	if idS == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No voter ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No voter ID provided")
+		return
+	}
+	id64, err := strconv.ParseInt(idS, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to int64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid voter ID")
+		return
+	}
+	cascade := c.Query("cascade") == "true"
+
+	//When cascading, a voter that is already gone is not an error so a
+	//request whose cleanup failed part way can simply be repeated.  Any
+	//other failure stops before the votes API is touched
+	if err := v.store(c).DeleteVoter(uint(id64)); err == nil {
+		votersDeleted.Inc()
+	} else if !(cascade && errors.Is(err, storage.ErrNotFound)) {
+		slog.ErrorContext(c.Request.Context(), "Error deleting item", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
-	id64, _ := strconv.ParseInt(idS, 10, 32)
 
-	if err := v.db.DeleteVoter(uint(id64)); err != nil {
-		log.Println("Error deleting item: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	if !cascade {
+		c.Status(http.StatusOK)
 		return
 	}
 
-	c.Status(http.StatusOK)
+	numDeleted, err := v.deleteVoterVotes(c.Request.Context(), uint(id64))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error deleting votes for voter", "error", err)
+		web.AbortWithError(c, http.StatusBadGateway, "Voter deleted but their votes were not: "+err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"votesDeleted": numDeleted})
+}
+
+// implementation for DELETE /voters/polls/:pollid
+// removes a poll from the history of every voter, used when a poll is
+// deleted
+func (v *VoterAPI) DeletePollFromHistories(c *gin.Context) {
+	idP := c.Param("pollid")
+	if idP == "" {
+		web.AbortWithError(c, http.StatusBadRequest, "No poll ID provided")
+		return
+	}
+	id64, err := strconv.ParseInt(idP, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting pollid to int64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid poll ID")
+		return
+	}
+
+	numUpdated, err := v.store(c).DeletePollFromHistories(uint(id64))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error removing poll from histories", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": numUpdated})
+}
+
+// Helper to delete all of a voter's votes through DELETE /voters/:id/votes
+// on the votes API.  Returns the number of votes deleted
+func (v *VoterAPI) deleteVoterVotes(ctx context.Context, voterId uint) (int, error) {
+	votesURL := fmt.Sprintf("%s/voters/%d/votes", v.votesAPIURL, voterId)
+	var result struct {
+		Deleted int `json:"deleted"`
+	}
+
+	resp, err := v.apiClient.R().SetContext(detach(ctx)).SetResult(&result).Delete(votesURL)
+	if err != nil {
+		return 0, err
+	}
+	if resp.IsError() {
+		return 0, fmt.Errorf("votes API returned %s for (%s)", resp.Status(), votesURL)
+	}
+
+	return result.Deleted, nil
 }
 
 // implementation for DELETE /todo
 // deletes all todos
 func (v *VoterAPI) DeleteAllVoters(c *gin.Context) {
 
-	if err := v.db.DeleteAll(); err != nil {
-		log.Println("Error deleting all items: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	if err := v.store(c).DeleteAll(); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error deleting all items", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
 
 	c.Status(http.StatusOK)
 }
 
-/*   SPECIAL HANDLERS FOR DEMONSTRATION - CRASH SIMULATION AND HEALTH CHECK */
+/*   SPECIAL HANDLERS FOR DEMONSTRATION - CRASH SIMULATION */
 
 // implementation for GET /crash
 // This simulates a crash to show some of the benefits of the
@@ -333,18 +481,3 @@ func (v *VoterAPI) CrashSim(c *gin.Context) {
 	//panic() is go's version of throwing an exception
 	panic("Simulating an unexpected crash")
 }
-
-// implementation of GET /health. It is a good practice to build in a
-// health check for your API.  Below the results are just hard coded
-// but in a real API you can provide detailed information about the
-// health of your API with a Health Check
-func (v *VoterAPI) HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK,
-		gin.H{
-			"status":             "ok",
-			"version":            "1.0.0",
-			"uptime":             100,
-			"users_processed":    1000,
-			"errors_encountered": 10,
-		})
-}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"drexel.edu/common/web"
+	"drexel.edu/voter-api/db"
+	"github.com/gin-gonic/gin"
+)
+
+// fakeVotesAPI stands in for the votes API.  It counts the requests it
+// gets by method and path and reports that nothing was deleted
+type fakeVotesAPI struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (f *fakeVotesAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.calls[r.Method+" "+r.URL.Path]++
+	f.mu.Unlock()
+
+	if r.Method != http.MethodDelete {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"deleted": 0}`))
+}
+
+func (f *fakeVotesAPI) count(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[key]
+}
+
+// Helper to build the voter routes on top of the memory store
+func newTestRouter(t *testing.T) (*gin.Engine, *fakeVotesAPI) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	votesAPI := &fakeVotesAPI{calls: make(map[string]int)}
+	server := httptest.NewServer(votesAPI)
+	t.Cleanup(server.Close)
+
+	apiHandler, err := New(db.StoreMemory, db.RedisConfig{}, "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { apiHandler.Close() })
+
+	r := gin.New()
+	r.GET("/voters", apiHandler.GetAllVoterResources)
+	r.GET("/voters/:id", apiHandler.GetSingleVoterResource)
+	r.POST("/voters", apiHandler.CreateVoter)
+	r.POST("/voters/:id", apiHandler.AddVoter)
+	r.GET("/voters/:id/polls", apiHandler.GetVoterHistory)
+	r.POST("/voters/:id/polls/:pollid", apiHandler.AddVoterPollData)
+	r.DELETE("/voters/:id", apiHandler.DeleteVoter)
+	r.DELETE("/voters/polls/:pollid", apiHandler.DeletePollFromHistories)
+	return r, votesAPI
+}
+
+// Helper to send a request to the router and return the recorded response
+func serve(r *gin.Engine, method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// Helper to check a response's status and, for errors, its code
+func checkResponse(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, status, w.Body)
+	}
+	if code == "" {
+		return
+	}
+	var resp web.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error body %s: %v", w.Body, err)
+	}
+	if resp.Code != code {
+		t.Errorf("code = %q, want %q", resp.Code, code)
+	}
+}
+
+// Helper to read a voter back through the API
+func getVoter(t *testing.T, r *gin.Engine, path string) db.Voter {
+	t.Helper()
+	w := serve(r, http.MethodGet, path, "")
+	checkResponse(t, w, http.StatusOK, "")
+
+	var voter db.Voter
+	if err := json.Unmarshal(w.Body.Bytes(), &voter); err != nil {
+		t.Fatal(err)
+	}
+	return voter
+}
+
+func TestCreateAndGetVoter(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	w := serve(r, http.MethodPost, "/voters", `{"firstname": "Ada", "lastname": "Lovelace"}`)
+	checkResponse(t, w, http.StatusCreated, "")
+	if got := w.Header().Get("Location"); got != "/voters/1" {
+		t.Errorf("Location = %q, want /voters/1", got)
+	}
+
+	voter := getVoter(t, r, "/voters/1")
+	if voter.VoterId != 1 || voter.FirstName != "Ada" || voter.LastName != "Lovelace" {
+		t.Errorf("GET returned %+v", voter)
+	}
+
+	checkResponse(t, serve(r, http.MethodPost, "/voters/5", `{"firstname": "Alan"}`), http.StatusOK, "")
+	checkResponse(t, serve(r, http.MethodPost, "/voters/5", `{"firstname": "Alan"}`), http.StatusConflict, web.CodeConflict)
+
+	w = serve(r, http.MethodGet, "/voters", "")
+	checkResponse(t, w, http.StatusOK, "")
+	var voters []db.Voter
+	if err := json.Unmarshal(w.Body.Bytes(), &voters); err != nil {
+		t.Fatal(err)
+	}
+	if len(voters) != 2 || voters[0].VoterId != 1 || voters[1].VoterId != 5 {
+		t.Errorf("listed %+v, want voters 1 and 5", voters)
+	}
+}
+
+func TestVoterRequestErrors(t *testing.T) {
+	r, _ := newTestRouter(t)
+	checkResponse(t, serve(r, http.MethodPost, "/voters", `{"firstname": "Ada"}`), http.StatusCreated, "")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{"invalid id", http.MethodGet, "/voters/abc", "", http.StatusBadRequest, web.CodeInvalidRequest},
+		{"missing voter", http.MethodGet, "/voters/99", "", http.StatusNotFound, web.CodeNotFound},
+		{"invalid json", http.MethodPost, "/voters", `{"firstname": `, http.StatusBadRequest, web.CodeInvalidRequest},
+		{"client chosen id", http.MethodPost, "/voters", `{"id": 5}`, http.StatusBadRequest, web.CodeInvalidRequest},
+		{"id mismatch", http.MethodPost, "/voters/2", `{"id": 3}`, http.StatusBadRequest, web.CodeInvalidRequest},
+		{"invalid poll id", http.MethodPost, "/voters/1/polls/abc", "", http.StatusBadRequest, web.CodeInvalidRequest},
+		{"invalid cursor", http.MethodGet, "/voters?cursor=abc", "", http.StatusBadRequest, web.CodeInvalidRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkResponse(t, serve(r, tt.method, tt.path, tt.body), tt.status, tt.code)
+		})
+	}
+}
+
+func TestVoterHistory(t *testing.T) {
+	r, _ := newTestRouter(t)
+	checkResponse(t, serve(r, http.MethodPost, "/voters", `{"firstname": "Ada"}`), http.StatusCreated, "")
+
+	//Recording the same poll twice leaves one entry
+	for i := 0; i < 2; i++ {
+		checkResponse(t, serve(r, http.MethodPost, "/voters/1/polls/7", ""), http.StatusOK, "")
+	}
+	checkResponse(t, serve(r, http.MethodPost, "/voters/1/polls/8", ""), http.StatusOK, "")
+	if voter := getVoter(t, r, "/voters/1"); len(voter.VoteHistory) != 2 {
+		t.Fatalf("history = %+v, want polls 7 and 8", voter.VoteHistory)
+	}
+
+	w := serve(r, http.MethodDelete, "/voters/polls/7", "")
+	checkResponse(t, w, http.StatusOK, "")
+	var result struct {
+		Updated int `json:"updated"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Updated != 1 {
+		t.Errorf("updated = %d, want 1", result.Updated)
+	}
+
+	history := getVoter(t, r, "/voters/1").VoteHistory
+	if len(history) != 1 || history[0].PollID != 8 {
+		t.Errorf("history = %+v, want only poll 8", history)
+	}
+}
+
+func TestDeleteVoter(t *testing.T) {
+	r, votesAPI := newTestRouter(t)
+	checkResponse(t, serve(r, http.MethodPost, "/voters", `{"firstname": "Ada"}`), http.StatusCreated, "")
+
+	//A bad id must not reach the votes API
+	checkResponse(t, serve(r, http.MethodDelete, "/voters/abc?cascade=true", ""), http.StatusBadRequest, web.CodeInvalidRequest)
+	if got := votesAPI.count("DELETE /voters/0/votes"); got != 0 {
+		t.Errorf("votes API got %d deletes for voter 0", got)
+	}
+
+	checkResponse(t, serve(r, http.MethodDelete, "/voters/1?cascade=true", ""), http.StatusOK, "")
+	if got := votesAPI.count("DELETE /voters/1/votes"); got != 1 {
+		t.Errorf("votes API got %d deletes, want 1", got)
+	}
+	checkResponse(t, serve(r, http.MethodGet, "/voters/1", ""), http.StatusNotFound, web.CodeNotFound)
+	checkResponse(t, serve(r, http.MethodDelete, "/voters/1", ""), http.StatusNotFound, web.CodeNotFound)
+}
+
+// failingDeleteStore is a store whose deletes fail the way they would if
+// the database could not be reached
+type failingDeleteStore struct {
+	db.VoterStore
+}
+
+func (s failingDeleteStore) WithContext(ctx context.Context) db.VoterStore {
+	return failingDeleteStore{s.VoterStore.WithContext(ctx)}
+}
+
+func (s failingDeleteStore) DeleteVoter(id uint) error {
+	return errors.New("connection refused")
+}
+
+func TestDeleteVoterStoreError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	votesAPI := &fakeVotesAPI{calls: make(map[string]int)}
+	server := httptest.NewServer(votesAPI)
+	defer server.Close()
+
+	apiHandler, err := New(db.StoreMemory, db.RedisConfig{}, "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer apiHandler.Close()
+	apiHandler.db = failingDeleteStore{apiHandler.db}
+
+	r := gin.New()
+	r.DELETE("/voters/:id", apiHandler.DeleteVoter)
+
+	//Only a voter that is already gone lets a cascade go ahead
+	checkResponse(t, serve(r, http.MethodDelete, "/voters/1?cascade=true", ""), http.StatusInternalServerError, web.CodeInternalError)
+	if got := votesAPI.count("DELETE /voters/1/votes"); got != 0 {
+		t.Errorf("votes API got %d deletes after the voter was not deleted", got)
+	}
+}
@@ -1,2 +1,2 @@
 #!/bin/bash
-docker build --tag voter-api-basic:v1  -f ./dockerfile.basic .
+docker build --tag voter-api-basic:v1  -f ./dockerfile.basic ..
@@ -0,0 +1,21 @@
+package db
+
+import (
+	"log/slog"
+
+	"drexel.edu/common/events"
+)
+
+// EventSource names this service on the domain events it publishes
+const EventSource = "voter-api"
+
+// Helper to append an event to the domain event stream.  The change the
+// event describes has already been written, so a failure to publish is
+// logged rather than returned
+func (c *cache) publishEvent(eventType string, entityId uint, data interface{}) {
+	err := events.Publish(c.context, c.cacheClient, c.key(events.StreamKey),
+		EventSource, eventType, entityId, data)
+	if err != nil {
+		slog.ErrorContext(c.context, "Error publishing event", "type", eventType, "error", err)
+	}
+}
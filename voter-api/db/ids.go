@@ -0,0 +1,4 @@
+package db
+
+// Counter used to allocate voter ids on the server
+const RedisIdSeqKey = "voter-id-seq"
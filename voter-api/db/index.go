@@ -0,0 +1,5 @@
+package db
+
+// Sorted set holding every voter id scored by the id itself, so voters can
+// be listed in id order a page at a time
+const RedisIndexKey = "voter-index"
@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"drexel.edu/common/storage"
+)
+
+// MemoryVoterList keeps voters in process memory instead of redis, so the
+// API can run without a redis container.  Nothing survives a restart, it
+// can't be shared between replicas and no domain events are published
+type MemoryVoterList struct {
+	mu          sync.Mutex
+	voters      map[uint]Voter
+	lastVoterId uint
+}
+
+func NewMemoryVoterList() *MemoryVoterList {
+	return &MemoryVoterList{
+		voters: make(map[uint]Voter),
+	}
+}
+
+// Ping always succeeds, there is nothing to reach
+func (m *MemoryVoterList) Ping() error {
+	return nil
+}
+
+// Close has nothing to release
+func (m *MemoryVoterList) Close() error {
+	return nil
+}
+
+// WithContext returns the list itself, there are no calls to trace
+func (m *MemoryVoterList) WithContext(ctx context.Context) VoterStore {
+	return m
+}
+
+// Helper to copy a voter so callers never share slices with the store
+func cloneVoter(voter Voter) Voter {
+	clone := voter
+	if voter.VoteHistory != nil {
+		clone.VoteHistory = append([]voterPoll(nil), voter.VoteHistory...)
+	}
+	return clone
+}
+
+// Helper to store a voter, the caller holds mu
+func (m *MemoryVoterList) saveVoter(voter Voter) {
+	m.voters[voter.VoterId] = cloneVoter(voter)
+	if voter.VoterId > m.lastVoterId {
+		m.lastVoterId = voter.VoterId
+	}
+}
+
+// Helper to list the voters in id order, the caller holds mu
+func (m *MemoryVoterList) sortedIds() []uint {
+	ids := make([]uint, 0, len(m.voters))
+	for id := range m.voters {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Helper to copy out the voters for a list of ids, the caller holds mu
+func (m *MemoryVoterList) getVoters(ids []uint) []Voter {
+	voterList := make([]Voter, 0, len(ids))
+	for _, id := range ids {
+		voterList = append(voterList, cloneVoter(m.voters[id]))
+	}
+	return voterList
+}
+
+func (m *MemoryVoterList) AddVoter(voter Voter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.voters[voter.VoterId]; ok {
+		return ErrVoterExists
+	}
+	m.saveVoter(voter)
+
+	return nil
+}
+
+func (m *MemoryVoterList) CreateVoter(voter *Voter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//Ids chosen by clients raise lastVoterId too, so the next id is free
+	voter.VoterId = m.lastVoterId + 1
+	m.saveVoter(*voter)
+
+	return nil
+}
+
+func (m *MemoryVoterList) UpdateVoter(voter Voter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.voters[voter.VoterId]; !ok {
+		return ErrVoterNotFound
+	}
+	m.saveVoter(voter)
+
+	return nil
+}
+
+func (m *MemoryVoterList) DeleteVoter(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.voters[id]; !ok {
+		return ErrVoterNotFound
+	}
+	delete(m.voters, id)
+
+	return nil
+}
+
+func (m *MemoryVoterList) DeleteAll() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.voters = make(map[uint]Voter)
+
+	return nil
+}
+
+func (m *MemoryVoterList) GetSingleVoterResource(id uint) (Voter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	voter, ok := m.voters[id]
+	if !ok {
+		return Voter{}, ErrVoterNotFound
+	}
+	return cloneVoter(voter), nil
+}
+
+func (m *MemoryVoterList) GetAllVoters() ([]Voter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.getVoters(m.sortedIds()), nil
+}
+
+func (m *MemoryVoterList) GetVotersPage(cursor string, limit int) ([]Voter, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids, next, err := storage.PageOfIds(m.sortedIds(), cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	return m.getVoters(ids), next, nil
+}
+
+func (m *MemoryVoterList) SearchVoters(text string, limit int) ([]Voter, error) {
+	voterList, _ := m.GetAllVoters()
+	return rankVoters(voterList, text, limit)
+}
+
+func (m *MemoryVoterList) GetVoterHistory(id uint) ([]voterPoll, error) {
+	voter, err := m.GetSingleVoterResource(id)
+	if err != nil {
+		return []voterPoll{}, err
+	}
+	return voter.VoteHistory, nil
+}
+
+func (m *MemoryVoterList) GetVoterPollData(voterId uint, pollId uint) (*voterPoll, error) {
+	voter, err := m.GetSingleVoterResource(voterId)
+	if err != nil {
+		return &voterPoll{}, err
+	}
+
+	for _, entry := range voter.VoteHistory {
+		if entry.PollID == pollId {
+			return &entry, nil
+		}
+	}
+	return nil, ErrVoterPollNotFound
+}
+
+func (m *MemoryVoterList) AddVoterPollData(voterId uint, pollId uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//Like the redis store, a voter we haven't seen yet is created
+	voter, ok := m.voters[voterId]
+	if !ok {
+		voter = Voter{VoterId: voterId}
+	}
+	for _, entry := range voter.VoteHistory {
+		if entry.PollID == pollId {
+			return nil
+		}
+	}
+	voter = cloneVoter(voter)
+	voter.VoteHistory = append(voter.VoteHistory, voterPoll{PollID: pollId, VoteDate: time.Now()})
+	m.saveVoter(voter)
+
+	return nil
+}
+
+func (m *MemoryVoterList) DeletePoll(voterId uint, pollId uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	voter, ok := m.voters[voterId]
+	if !ok {
+		return ErrVoterNotFound
+	}
+
+	voter = cloneVoter(voter)
+	if !removePollFromHistory(&voter, pollId) {
+		return ErrVoterPollNotFound
+	}
+	m.saveVoter(voter)
+
+	return nil
+}
+
+func (m *MemoryVoterList) DeletePollFromHistories(pollId uint) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	numUpdated := 0
+	for _, id := range m.sortedIds() {
+		voter := cloneVoter(m.voters[id])
+		if removePollFromHistory(&voter, pollId) {
+			m.saveVoter(voter)
+			numUpdated++
+		}
+	}
+
+	return numUpdated, nil
+}
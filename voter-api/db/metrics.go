@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Redis commands are labelled with their name, like json.get or zadd.
+// Pipelines are timed as a whole and labelled pipeline
+var (
+	redisCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "redis_command_duration_seconds",
+		Help:    "Time taken by redis commands, by command.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+
+	redisCommandErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_command_errors_total",
+		Help: "Redis commands that failed, by command.  A missing key is not a failure.",
+	}, []string{"command"})
+)
+
+// metricsHook times every command the redis client sends
+type metricsHook struct{}
+
+// Key the start time of a command is kept under in its context
+type commandStartKey struct{}
+
+func (metricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, commandStartKey{}, time.Now()), nil
+}
+
+func (metricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observeCommand(ctx, cmd.Name(), cmd.Err())
+	return nil
+}
+
+func (metricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, commandStartKey{}, time.Now()), nil
+}
+
+func (metricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	observeCommand(ctx, "pipeline", pipelineErr(cmds))
+	return nil
+}
+
+// Helper to find the first real failure in a pipeline
+func pipelineErr(cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		if cmd.Err() != nil && !isRedisNilError(cmd.Err()) {
+			return cmd.Err()
+		}
+	}
+	return nil
+}
+
+// Helper to record how long a command took and whether it failed
+func observeCommand(ctx context.Context, name string, err error) {
+	if start, ok := ctx.Value(commandStartKey{}).(time.Time); ok {
+		redisCommandDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+	if err != nil && !isRedisNilError(err) {
+		redisCommandErrors.WithLabelValues(name).Inc()
+	}
+}
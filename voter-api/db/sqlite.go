@@ -0,0 +1,414 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"drexel.edu/common/storage"
+	_ "modernc.org/sqlite"
+)
+
+// DefaultSQLitePath is where the sqlite store keeps its database unless
+// told otherwise
+const DefaultSQLitePath = "voters.db"
+
+// A voter's history belongs to the voter and goes with it when the voter
+// is deleted.  position keeps the history in the order it was recorded
+// and vote dates are stored as RFC 3339 text
+const sqliteVoterSchema = `
+CREATE TABLE IF NOT EXISTS voters (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	firstname TEXT NOT NULL,
+	lastname  TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS vote_history (
+	voter_id  INTEGER NOT NULL REFERENCES voters (id) ON DELETE CASCADE,
+	position  INTEGER NOT NULL,
+	poll_id   INTEGER NOT NULL,
+	vote_date TEXT NOT NULL,
+	PRIMARY KEY (voter_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS vote_history_poll_id ON vote_history (poll_id);
+`
+
+// SQLiteVoterList keeps voters in a sqlite database file, so they survive
+// restarts without redis.  Replicas on the same host can share the file,
+// but no domain events are published and search is done in process
+type SQLiteVoterList struct {
+	db *sql.DB
+}
+
+// NewSQLiteVoterList opens, or creates, the database at path
+func NewSQLiteVoterList(path string) (*SQLiteVoterList, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(sqliteVoterSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteVoterList{db: db}, nil
+}
+
+// Ping checks that the database file can still be used
+func (s *SQLiteVoterList) Ping() error {
+	return s.db.Ping()
+}
+
+// Close closes the database file
+func (s *SQLiteVoterList) Close() error {
+	return s.db.Close()
+}
+
+// WithContext returns the list itself, only redis commands are traced
+func (s *SQLiteVoterList) WithContext(ctx context.Context) VoterStore {
+	return s
+}
+
+// Helper to open a sqlite database with foreign keys turned on.  Write
+// transactions take the write lock up front and wait for other writers,
+// instead of failing when two of them try to upgrade at once
+func openSQLite(path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_txlock=immediate" +
+		"&_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Helper to run fn in a transaction, committing if it succeeds
+func (s *SQLiteVoterList) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// querier is what the read helpers need, so they work both inside and
+// outside a transaction
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Helper to read the voters a query selects, along with their histories.
+// The query has to select from voters in id order
+func loadVoters(q querier, query string, args ...interface{}) ([]Voter, error) {
+	rows, err := q.Query(`SELECT id, firstname, lastname FROM voters `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	voterList := []Voter{}
+	byId := make(map[uint]int)
+	for rows.Next() {
+		var voter Voter
+		if err := rows.Scan(&voter.VoterId, &voter.FirstName, &voter.LastName); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		voter.VoteHistory = []voterPoll{}
+		byId[voter.VoterId] = len(voterList)
+		voterList = append(voterList, voter)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(voterList) == 0 {
+		return voterList, nil
+	}
+
+	//The voters are in id order, so their histories are all in this range
+	rows, err = q.Query(`SELECT voter_id, poll_id, vote_date FROM vote_history
+		WHERE voter_id BETWEEN ? AND ? ORDER BY voter_id, position`,
+		voterList[0].VoterId, voterList[len(voterList)-1].VoterId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var voterId uint
+		var entry voterPoll
+		var voteDate string
+		if err := rows.Scan(&voterId, &entry.PollID, &voteDate); err != nil {
+			return nil, err
+		}
+		if entry.VoteDate, err = time.Parse(time.RFC3339Nano, voteDate); err != nil {
+			return nil, err
+		}
+		if i, ok := byId[voterId]; ok {
+			voterList[i].VoteHistory = append(voterList[i].VoteHistory, entry)
+		}
+	}
+
+	return voterList, rows.Err()
+}
+
+// Helper to read a single voter
+func loadVoter(q querier, id uint) (Voter, error) {
+	voterList, err := loadVoters(q, "WHERE id = ?", id)
+	if err != nil {
+		return Voter{}, err
+	}
+	if len(voterList) == 0 {
+		return Voter{}, ErrVoterNotFound
+	}
+	return voterList[0], nil
+}
+
+// Helper to check whether a voter is stored
+func voterExistsTx(tx *sql.Tx, id uint) (bool, error) {
+	var n int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM voters WHERE id = ?`, id).Scan(&n)
+	return n > 0, err
+}
+
+// Helper to insert a new voter.  With allocate set the voter takes the
+// next free id, otherwise it keeps its own
+func insertVoter(tx *sql.Tx, voter *Voter, allocate bool) error {
+	var id interface{}
+	if !allocate {
+		id = voter.VoterId
+	}
+
+	res, err := tx.Exec(`INSERT INTO voters (id, firstname, lastname) VALUES (?, ?, ?)`,
+		id, voter.FirstName, voter.LastName)
+	if err != nil {
+		return err
+	}
+
+	if allocate {
+		newId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		voter.VoterId = uint(newId)
+	}
+	return writeHistory(tx, *voter)
+}
+
+// Helper to replace a stored voter's history with the one on voter
+func writeHistory(tx *sql.Tx, voter Voter) error {
+	if _, err := tx.Exec(`DELETE FROM vote_history WHERE voter_id = ?`, voter.VoterId); err != nil {
+		return err
+	}
+	for position, entry := range voter.VoteHistory {
+		_, err := tx.Exec(`INSERT INTO vote_history (voter_id, position, poll_id, vote_date)
+			VALUES (?, ?, ?, ?)`,
+			voter.VoterId, position, entry.PollID, entry.VoteDate.UTC().Format(time.RFC3339Nano))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteVoterList) AddVoter(voter Voter) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		exists, err := voterExistsTx(tx, voter.VoterId)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrVoterExists
+		}
+		return insertVoter(tx, &voter, false)
+	})
+}
+
+// CreateVoter adds a voter under the next free id and writes that id back
+// into the voter.  sqlite remembers the highest id it has seen, including
+// ids chosen by clients, so the id is always free
+func (s *SQLiteVoterList) CreateVoter(voter *Voter) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		return insertVoter(tx, voter, true)
+	})
+}
+
+func (s *SQLiteVoterList) UpdateVoter(voter Voter) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE voters SET firstname = ?, lastname = ? WHERE id = ?`,
+			voter.FirstName, voter.LastName, voter.VoterId)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrVoterNotFound
+		}
+		return writeHistory(tx, voter)
+	})
+}
+
+func (s *SQLiteVoterList) DeleteVoter(id uint) error {
+	//The history goes with the voter
+	res, err := s.db.Exec(`DELETE FROM voters WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrVoterNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteVoterList) DeleteAll() error {
+	_, err := s.db.Exec(`DELETE FROM voters`)
+	return err
+}
+
+func (s *SQLiteVoterList) GetSingleVoterResource(id uint) (Voter, error) {
+	return loadVoter(s.db, id)
+}
+
+func (s *SQLiteVoterList) GetAllVoters() ([]Voter, error) {
+	return loadVoters(s.db, "ORDER BY id")
+}
+
+func (s *SQLiteVoterList) GetVotersPage(cursor string, limit int) ([]Voter, string, error) {
+	limit = storage.PageLimit(limit)
+
+	var after uint
+	if cursor != "" {
+		var err error
+		if after, err = storage.ParseCursor(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	//Read one voter past the page to find out whether there is another
+	voterList, err := loadVoters(s.db, "WHERE id > ? ORDER BY id LIMIT ?", after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(voterList) <= limit {
+		return voterList, "", nil
+	}
+
+	ids := make([]uint, len(voterList))
+	for i, voter := range voterList {
+		ids[i] = voter.VoterId
+	}
+	_, next, err := storage.TrimPage(ids, limit)
+	return voterList[:limit], next, err
+}
+
+func (s *SQLiteVoterList) SearchVoters(text string, limit int) ([]Voter, error) {
+	if len(searchWords(text)) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	voterList, err := s.GetAllVoters()
+	if err != nil {
+		return nil, err
+	}
+	return rankVoters(voterList, text, limit)
+}
+
+func (s *SQLiteVoterList) GetVoterHistory(id uint) ([]voterPoll, error) {
+	voter, err := s.GetSingleVoterResource(id)
+	if err != nil {
+		return []voterPoll{}, err
+	}
+	return voter.VoteHistory, nil
+}
+
+func (s *SQLiteVoterList) GetVoterPollData(voterId uint, pollId uint) (*voterPoll, error) {
+	voter, err := s.GetSingleVoterResource(voterId)
+	if err != nil {
+		return &voterPoll{}, err
+	}
+
+	for _, entry := range voter.VoteHistory {
+		if entry.PollID == pollId {
+			return &entry, nil
+		}
+	}
+	return nil, ErrVoterPollNotFound
+}
+
+func (s *SQLiteVoterList) AddVoterPollData(voterId uint, pollId uint) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		//Like the redis store, a voter we haven't seen yet is created
+		exists, err := voterExistsTx(tx, voterId)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if err := insertVoter(tx, &Voter{VoterId: voterId}, false); err != nil {
+				return err
+			}
+		}
+
+		//A poll already in the history is left as it is
+		_, err = tx.Exec(`INSERT INTO vote_history (voter_id, position, poll_id, vote_date)
+			SELECT ?, COALESCE(MAX(position), -1) + 1, ?, ? FROM vote_history WHERE voter_id = ?
+			HAVING NOT EXISTS (SELECT 1 FROM vote_history WHERE voter_id = ? AND poll_id = ?)`,
+			voterId, pollId, time.Now().UTC().Format(time.RFC3339Nano), voterId, voterId, pollId)
+		return err
+	})
+}
+
+func (s *SQLiteVoterList) DeletePoll(voterId uint, pollId uint) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		exists, err := voterExistsTx(tx, voterId)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrVoterNotFound
+		}
+
+		res, err := tx.Exec(`DELETE FROM vote_history WHERE voter_id = ? AND poll_id = ?`, voterId, pollId)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrVoterPollNotFound
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteVoterList) DeletePollFromHistories(pollId uint) (int, error) {
+	var numUpdated int
+	err := s.inTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`SELECT COUNT(DISTINCT voter_id) FROM vote_history WHERE poll_id = ?`,
+			pollId).Scan(&numUpdated)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`DELETE FROM vote_history WHERE poll_id = ?`, pollId)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return numUpdated, nil
+}
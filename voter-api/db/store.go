@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"drexel.edu/common/config"
+)
+
+// The stores NewStore knows how to build
+const (
+	StoreRedis  = config.StoreRedis
+	StoreMemory = config.StoreMemory
+	StoreSQLite = config.StoreSQLite
+)
+
+// VoterStore is everything the API needs from the place voters are kept.
+// VoterList keeps them in redis, MemoryVoterList keeps them in process
+// memory and SQLiteVoterList keeps them in a sqlite file
+type VoterStore interface {
+	AddVoter(voter Voter) error
+	CreateVoter(voter *Voter) error
+	UpdateVoter(voter Voter) error
+	DeleteVoter(id uint) error
+	DeleteAll() error
+	GetSingleVoterResource(id uint) (Voter, error)
+	GetAllVoters() ([]Voter, error)
+	GetVotersPage(cursor string, limit int) ([]Voter, string, error)
+	SearchVoters(text string, limit int) ([]Voter, error)
+
+	GetVoterHistory(id uint) ([]voterPoll, error)
+	GetVoterPollData(voterId uint, pollId uint) (*voterPoll, error)
+	AddVoterPollData(voterId uint, pollId uint) error
+	DeletePoll(voterId uint, pollId uint) error
+	DeletePollFromHistories(pollId uint) (int, error)
+
+	Ping() error
+	Close() error
+	WithContext(ctx context.Context) VoterStore
+}
+
+var (
+	_ VoterStore = (*VoterList)(nil)
+	_ VoterStore = (*MemoryVoterList)(nil)
+	_ VoterStore = (*SQLiteVoterList)(nil)
+)
+
+// NewStore builds the store named by kind, one of StoreRedis, StoreMemory
+// or StoreSQLite.  redisCfg is only used by StoreRedis, sqlitePath is the
+// database file and is only used by StoreSQLite
+func NewStore(kind string, redisCfg RedisConfig, sqlitePath string) (VoterStore, error) {
+	switch kind {
+	case StoreRedis:
+		return NewWithConfig(redisCfg)
+	case StoreMemory:
+		return NewMemoryVoterList(), nil
+	case StoreSQLite:
+		return NewSQLiteVoterList(sqlitePath)
+	}
+	return nil, fmt.Errorf("unknown store %q, use %s, %s or %s", kind, StoreRedis, StoreMemory, StoreSQLite)
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("drexel.edu/voter-api/db")
+
+// tracingHook gives every command the redis client sends its own span,
+// under whatever span is in the command's context
+type tracingHook struct{}
+
+// Helper to start the span for a command
+func startCommandSpan(ctx context.Context, name string) context.Context {
+	ctx, _ = tracer.Start(ctx, "redis "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation", name),
+		))
+	return ctx
+}
+
+// Helper to end the span for a command, marking it failed if it was.  A
+// missing key is not a failure
+func endCommandSpan(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	if err != nil && !isRedisNilError(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return startCommandSpan(ctx, cmd.Name()), nil
+}
+
+func (tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endCommandSpan(ctx, cmd.Err())
+	return nil
+}
+
+func (tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return startCommandSpan(ctx, "pipeline"), nil
+}
+
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	endCommandSpan(ctx, pipelineErr(cmds))
+	return nil
+}
+
+// Helper to keep the trace and request id in ctx but drop its
+// cancellation, so a client hanging up can't abandon a write half way
+// through
+func detach(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
+}
@@ -10,10 +10,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 	"time"
 
+	"drexel.edu/common/events"
+	"drexel.edu/common/storage"
 	"github.com/go-redis/redis/v8"
 	"github.com/nitishm/go-rejson/v4"
 )
@@ -24,10 +25,21 @@ const (
 	RedisKeyPrefix       = "voter:"
 )
 
+// How many times AddVoterPollData retries when another writer changes the
+// voter underneath it
+const maxModifyAttempts = 5
+
+var (
+	ErrVoterExists       = storage.NewError(storage.ErrConflict, "voter already exists")
+	ErrVoterNotFound     = storage.NewError(storage.ErrNotFound, "voter does not exist")
+	ErrVoterPollNotFound = storage.NewError(storage.ErrNotFound, "voter has no vote in that poll")
+)
+
 type cache struct {
 	cacheClient *redis.Client
 	jsonHelper  *rejson.Handler
 	context     context.Context
+	keyPrefix   string
 }
 
 // ToDoItem is the struct that represents a single ToDo item
@@ -70,29 +82,21 @@ func (v *Voter) AddPoll(pollID uint) {
 	v.VoteHistory = append(v.VoteHistory, voterPoll{PollID: pollID, VoteDate: time.Now()})
 }
 
-func NewVoterList() (*VoterList, error) {
-
-	//We will use an override if the REDIS_URL is provided as an environment
-	//variable, which is the preferred way to wire up a docker container
-	redisUrl := os.Getenv("REDIS_URL")
-	//This handles the default condition
-	if redisUrl == "" {
-		redisUrl = RedisDefaultLocation
-	}
-	return NewWithCacheInstance(redisUrl)
-
-}
-
 // NewWithCacheInstance is a constructor function that returns a pointer to a new
 // ToDo struct.  It accepts a string that represents the location of the redis
 // cache.
 func NewWithCacheInstance(location string) (*VoterList, error) {
+	return NewWithConfig(RedisConfig{Addr: location})
+}
 
-	//Connect to redis.  Other options can be provided, but the
-	//defaults are OK
-	client := redis.NewClient(&redis.Options{
-		Addr: location,
-	})
+// NewWithConfig connects to the redis described by cfg and returns a list
+// that keeps voters there
+func NewWithConfig(cfg RedisConfig) (*VoterList, error) {
+
+	//Connect to redis
+	client := redis.NewClient(redisOptions(cfg))
+	client.AddHook(metricsHook{})
+	client.AddHook(tracingHook{})
 
 	//We use this context to coordinate betwen our go code and
 	//the redis operaitons
@@ -102,7 +106,7 @@ func NewWithCacheInstance(location string) (*VoterList, error) {
 	//is working
 	err := client.Ping(ctx).Err()
 	if err != nil {
-		log.Println("Error connecting to redis" + err.Error())
+		slog.Error("Error connecting to redis", "error", err)
 		return nil, err
 	}
 
@@ -115,14 +119,63 @@ func NewWithCacheInstance(location string) (*VoterList, error) {
 	jsonHelper := rejson.NewReJSONHandler()
 	jsonHelper.SetGoRedisClientWithContext(ctx, client)
 
+	voterList := &VoterList{
+		cache: cache{
+			cacheClient: client,
+			jsonHelper:  jsonHelper,
+			context:     ctx,
+			keyPrefix:   cfg.KeyPrefix,
+		},
+	}
+
+	//Voters stored before the index existed still need to be listed
+	if err := storage.RebuildIndex(voterList.context, voterList.cacheClient, voterList.key(RedisIndexKey), voterList.key(RedisKeyPrefix)); err != nil {
+		slog.Error("Error rebuilding voter index", "error", err)
+		return nil, err
+	}
+
+	//Server allocated ids have to start past the voters already stored
+	if err := storage.RebuildIdSeq(voterList.context, voterList.cacheClient, voterList.key(RedisIdSeqKey), voterList.key(RedisKeyPrefix)); err != nil {
+		slog.Error("Error rebuilding voter id sequence", "error", err)
+		return nil, err
+	}
+
+	//Search is only an extra, so a redis without RediSearch is not fatal
+	if err := voterList.ensureSearchIndex(); err != nil {
+		slog.Error("Error creating voter search index, search is disabled", "error", err)
+	}
+
 	//Return a pointer to a new ToDo struct
+	return voterList, nil
+}
+
+// Ping checks that redis can still be reached
+func (lst *VoterList) Ping() error {
+	return lst.cacheClient.Ping(lst.context).Err()
+}
+
+// Close closes the redis client.  Copies made by WithContext share the
+// client, so it only needs closing once
+func (lst *VoterList) Close() error {
+	return lst.cacheClient.Close()
+}
+
+// WithContext returns a copy of the list that sends its commands with the
+// trace and request id in ctx, so they show up in the request's trace and
+// logs.  ctx's deadline and cancellation are not used
+func (lst *VoterList) WithContext(ctx context.Context) VoterStore {
+	ctx = detach(ctx)
+	jsonHelper := rejson.NewReJSONHandler()
+	jsonHelper.SetGoRedisClientWithContext(ctx, lst.cacheClient)
+
 	return &VoterList{
 		cache: cache{
-			cacheClient: client,
+			cacheClient: lst.cacheClient,
 			jsonHelper:  jsonHelper,
 			context:     ctx,
+			keyPrefix:   lst.keyPrefix,
 		},
-	}, nil
+	}
 }
 
 //------------------------------------------------------------
@@ -137,8 +190,8 @@ func isRedisNilError(err error) bool {
 // In redis, our keys will be strings, they will look like
 // todo:<number>.  This function will take an integer and
 // return a string that can be used as a key in redis
-func redisKeyFromId(id int) string {
-	return fmt.Sprintf("%s%d", RedisKeyPrefix, id)
+func (c *cache) redisKeyFromId(id int) string {
+	return fmt.Sprintf("%s%d", c.key(RedisKeyPrefix), id)
 }
 
 // Helper to return a ToDoItem from redis provided a key
@@ -149,7 +202,7 @@ func (v *VoterList) getItemFromRedis(key string, item *Voter) error {
 	//json structure
 	itemObject, err := v.jsonHelper.JSONGet(key, ".")
 	if err != nil {
-		return err
+		return storage.FromRedis(err, ErrVoterNotFound)
 	}
 
 	//JSONGet returns an "any" object, or empty interface,
@@ -158,7 +211,7 @@ func (v *VoterList) getItemFromRedis(key string, item *Voter) error {
 	//it into our ToDoItem struct
 	err = json.Unmarshal(itemObject.([]byte), item)
 	if err != nil {
-		return nil
+		return storage.CorruptRecord(key, err)
 	}
 
 	return nil
@@ -176,46 +229,98 @@ func (lst *VoterList) AddVoter(voter Voter) error {
 	//Before we add an item to the DB, lets make sure
 	//it does not exist, if it does, return an error
 
-	redisKey := redisKeyFromId(int(voter.VoterId))
+	redisKey := lst.redisKeyFromId(int(voter.VoterId))
+	//A voter that can't be read still takes up the id
 	var existingVoter Voter
-	if err := lst.getItemFromRedis(redisKey, &existingVoter); err == nil {
-		return errors.New("voter already exists")
+	switch err := lst.getItemFromRedis(redisKey, &existingVoter); {
+	case err == nil, errors.Is(err, storage.ErrCorruptRecord):
+		return ErrVoterExists
+	case !errors.Is(err, ErrVoterNotFound):
+		return err
 	}
 
 	//Add item to database with JSON Set
 	if _, err := lst.jsonHelper.JSONSet(redisKey, ".", voter); err != nil {
 		return err
 	}
+	if err := storage.IndexId(lst.context, lst.cacheClient, lst.key(RedisIndexKey), voter.VoterId); err != nil {
+		return err
+	}
+	if err := storage.RaiseIdSeq(lst.context, lst.cacheClient, lst.key(RedisIdSeqKey), voter.VoterId); err != nil {
+		return err
+	}
+	lst.publishEvent(events.VoterCreated, voter.VoterId, voter)
 
 	//If everything is ok, return nil for the error
 	return nil
 }
 
+// CreateVoter adds a voter under the next server allocated id and writes
+// that id back into the voter
+func (lst *VoterList) CreateVoter(voter *Voter) error {
+	for attempt := 0; attempt < storage.MaxIdAttempts; attempt++ {
+		id, err := storage.NextId(lst.context, lst.cacheClient, lst.key(RedisIdSeqKey))
+		if err != nil {
+			return err
+		}
+		voter.VoterId = id
+
+		//A client may have claimed this id through POST /voters/:id
+		//between the increment and the write, if so just take the next one
+		if err := lst.AddVoter(*voter); !errors.Is(err, ErrVoterExists) {
+			return err
+		}
+	}
+	return errors.New("could not allocate a voter id")
+}
+
 func (lst *VoterList) DeleteVoter(id uint) error {
 
-	pattern := redisKeyFromId(int(id))
+	pattern := lst.redisKeyFromId(int(id))
 	numDeleted, err := lst.cacheClient.Del(lst.context, pattern).Result()
 	if err != nil {
 		return err
 	}
 	if numDeleted == 0 {
-		return errors.New("attempted to delete non-existent item")
+		return ErrVoterNotFound
+	}
+	if err := storage.UnindexId(lst.context, lst.cacheClient, lst.key(RedisIndexKey), id); err != nil {
+		return err
 	}
+	lst.publishEvent(events.VoterDeleted, id, nil)
 
 	return nil
 }
 
 func (lst *VoterList) DeleteAll() error {
-	pattern := RedisKeyPrefix + "*"
-	ks, _ := lst.cacheClient.Keys(lst.context, pattern).Result()
+	pattern := lst.key(RedisKeyPrefix) + "*"
+	ks, err := storage.ScanKeys(lst.context, lst.cacheClient, pattern)
+	if err != nil {
+		return err
+	}
+
 	//Note delete can take a collection of keys.  In go we can
 	//expand a slice into individual arguments by using the ...
-	//operator
-	numDeleted, err := lst.cacheClient.Del(lst.context, ks...).Result()
-	if err != nil {
+	//operator.  Redis rejects a DEL without any keys, so only
+	//call it when there is something to delete
+	var numDeleted int64
+	if len(ks) > 0 {
+		numDeleted, err = lst.cacheClient.Del(lst.context, ks...).Result()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := lst.cacheClient.Del(lst.context, lst.key(RedisIndexKey)).Err(); err != nil {
 		return err
 	}
 
+	for _, key := range ks {
+		if id, err := storage.IdFromKey(key, lst.key(RedisKeyPrefix)); err == nil {
+			lst.publishEvent(events.VoterDeleted, id, nil)
+		}
+	}
+
 	if numDeleted != int64(len(ks)) {
 		return errors.New("one or more items could not be deleted")
 	}
@@ -227,10 +332,10 @@ func (lst *VoterList) UpdateVoter(voter Voter) error {
 
 	//Before we add an item to the DB, lets make sure
 	//it does not exist, if it does, return an error
-	redisKey := redisKeyFromId(int(voter.VoterId))
+	redisKey := lst.redisKeyFromId(int(voter.VoterId))
 	var existingItem Voter
 	if err := lst.getItemFromRedis(redisKey, &existingItem); err != nil {
-		return errors.New("item does not exist")
+		return err
 	}
 
 	//Add item to database with JSON Set.  Note there is no update
@@ -238,6 +343,7 @@ func (lst *VoterList) UpdateVoter(voter Voter) error {
 	if _, err := lst.jsonHelper.JSONSet(redisKey, ".", voter); err != nil {
 		return err
 	}
+	lst.publishEvent(events.VoterUpdated, voter.VoterId, voter)
 
 	//If everything is ok, return nil for the error
 	return nil
@@ -253,7 +359,7 @@ func (lst *VoterList) GetSingleVoterResource(id uint) (Voter, error) {
 	// this is a good practice, return an error if the
 	// item does not exist
 	var voter Voter
-	pattern := redisKeyFromId(int(id))
+	pattern := lst.redisKeyFromId(int(id))
 	err := lst.getItemFromRedis(pattern, &voter)
 	if err != nil {
 		return Voter{}, err
@@ -295,7 +401,7 @@ Gets JUST the voter history for the voter with VoterID = :id
 func (lst *VoterList) GetVoterHistory(id uint) ([]voterPoll, error) {
 
 	var voter Voter
-	pattern := redisKeyFromId(int(id))
+	pattern := lst.redisKeyFromId(int(id))
 	err := lst.getItemFromRedis(pattern, &voter)
 	if err != nil {
 		return []voterPoll{}, err
@@ -311,17 +417,48 @@ discuss the concept of "paging" later, for now you can ignore)
 */
 func (lst *VoterList) GetAllVoters() ([]Voter, error) {
 
+	//The index keeps the ids in order, so the voters come back sorted
+	ids, err := storage.SetIds(lst.context, lst.cacheClient, lst.key(RedisIndexKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return lst.getVoters(ids)
+}
+
+// GetVotersPage returns up to limit voters with ids after cursor, along
+// with the cursor for the next page.  The returned cursor is empty once
+// the last page has been read
+func (lst *VoterList) GetVotersPage(cursor string, limit int) ([]Voter, string, error) {
+	ids, next, err := storage.RangeIds(lst.context, lst.cacheClient, lst.key(RedisIndexKey), cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	voterList, err := lst.getVoters(ids)
+	if err != nil {
+		return nil, "", err
+	}
+	return voterList, next, nil
+}
+
+// Helper to load the voters for a list of ids, skipping any that were
+// deleted since the ids were read.  A record that can't be decoded fails
+// the whole read rather than quietly going missing from the list
+func (lst *VoterList) getVoters(ids []uint) ([]Voter, error) {
+
+	docs, err := storage.GetDocuments(lst.jsonHelper, lst.key(RedisKeyPrefix), ids)
+	if err != nil {
+		return nil, err
+	}
+
 	//Now that we have the DB loaded, lets crate a slice
-	var voterList []Voter
+	voterList := make([]Voter, 0, len(docs))
 
-	//Lets query redis for all of the items
-	pattern := RedisKeyPrefix + "*"
-	ks, _ := lst.cacheClient.Keys(lst.context, pattern).Result()
-	for _, key := range ks {
+	for _, doc := range docs {
 		var voter Voter
-		err := lst.getItemFromRedis(key, &voter)
-		if err != nil {
-			return nil, err
+		if err := json.Unmarshal(doc.JSON, &voter); err != nil {
+			return nil, storage.CorruptRecord(doc.Key, err)
 		}
 		voterList = append(voterList, voter)
 	}
@@ -335,7 +472,7 @@ Gets JUST the single voter poll data with PollID = :id and VoterID = :id.
 func (lst *VoterList) GetVoterPollData(voterId uint, pollId uint) (*voterPoll, error) {
 
 	var currentVoter Voter
-	pattern := redisKeyFromId(int(voterId))
+	pattern := lst.redisKeyFromId(int(voterId))
 	err := lst.getItemFromRedis(pattern, &currentVoter)
 	if err != nil {
 		return &voterPoll{}, err
@@ -348,75 +485,161 @@ func (lst *VoterList) GetVoterPollData(voterId uint, pollId uint) (*voterPoll, e
 		}
 	}
 
-	return nil, errors.New("item does not exist")
+	return nil, ErrVoterPollNotFound
 
 }
 
+/*
+Records that the voter with VoterID = :id voted in the poll with PollID =
+:pollid.  A voter we haven't seen yet is created, and a poll that is
+already in the voter's history is left as it is, so repeating the call is
+harmless
+*/
 func (lst *VoterList) AddVoterPollData(voterId uint, pollId uint) error {
 
+	redisKey := lst.redisKeyFromId(int(voterId))
 	var currentVoter Voter
-	pattern := redisKeyFromId(int(voterId))
-	err := lst.getItemFromRedis(pattern, &currentVoter)
-	if err != nil {
-		newVoter := Voter{
-			VoterId: voterId,
+	var created, changed bool
+
+	//The voter is watched so two votes recorded at the same time can't
+	//both read the old history and have one overwrite the other
+	txf := func(tx *redis.Tx) error {
+		getCmd := redis.NewCmd(lst.context, "JSON.GET", redisKey, ".")
+		_ = tx.Process(lst.context, getCmd)
+		voterJSON, err := getCmd.Text()
+
+		currentVoter = Voter{VoterId: voterId}
+		created, changed = false, false
+		switch {
+		case err == nil:
+			if err := json.Unmarshal([]byte(voterJSON), &currentVoter); err != nil {
+				return storage.CorruptRecord(redisKey, err)
+			}
+		case isRedisNilError(err):
+			created = true
+		default:
+			return err
 		}
-		// lst.Voters[voterId] = newVoter
 
-		newPoll := voterPoll{
-			PollID:   pollId,
-			VoteDate: time.Now(),
+		for _, entry := range currentVoter.VoteHistory {
+			if entry.PollID == pollId {
+				return nil
+			}
 		}
-		newVoter.VoteHistory = append(newVoter.VoteHistory, newPoll)
+		currentVoter.AddPoll(pollId)
+		changed = true
 
-		//Add item to database with JSON Set
-		redisKey := redisKeyFromId(int(voterId))
-		if _, err := lst.jsonHelper.JSONSet(redisKey, ".", newVoter); err != nil {
+		updatedJSON, err := json.Marshal(currentVoter)
+		if err != nil {
 			return err
 		}
-		// lst.Voters[voterId] = newVoter
-
-		return nil
+		_, err = tx.TxPipelined(lst.context, func(pipe redis.Pipeliner) error {
+			pipe.Do(lst.context, "JSON.SET", redisKey, ".", string(updatedJSON))
+			return nil
+		})
+		return err
 	}
 
-	newPoll := voterPoll{
-		PollID:   pollId,
-		VoteDate: time.Now(),
+	var err error
+	for attempt := 0; attempt < maxModifyAttempts; attempt++ {
+		if err = lst.cacheClient.Watch(lst.context, txf, redisKey); !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
 	}
-	currentVoter.VoteHistory = append(currentVoter.VoteHistory, newPoll)
-
-	//Add item to database with JSON Set
-	redisKey := redisKeyFromId(int(voterId))
-	if _, err := lst.jsonHelper.JSONSet(redisKey, ".", currentVoter); err != nil {
+	if errors.Is(err, redis.TxFailedErr) {
+		return storage.NewError(storage.ErrConflict, "voter kept changing while their history was being updated")
+	}
+	if err != nil || !changed {
 		return err
 	}
 
+	if created {
+		if err := storage.IndexId(lst.context, lst.cacheClient, lst.key(RedisIndexKey), voterId); err != nil {
+			return err
+		}
+		if err := storage.RaiseIdSeq(lst.context, lst.cacheClient, lst.key(RedisIdSeqKey), voterId); err != nil {
+			return err
+		}
+		lst.publishEvent(events.VoterCreated, currentVoter.VoterId, currentVoter)
+		return nil
+	}
+	lst.publishEvent(events.VoterUpdated, currentVoter.VoterId, currentVoter)
+
 	return nil
 }
 
 func (lst *VoterList) DeletePoll(voterId uint, pollId uint) error {
 
 	var currentVoter Voter
-	pattern := redisKeyFromId(int(voterId))
+	pattern := lst.redisKeyFromId(int(voterId))
 	err := lst.getItemFromRedis(pattern, &currentVoter)
 	if err != nil {
 		return err
 	}
 
-	index := 0
+	index := -1
 	for j := 0; j < len(currentVoter.VoteHistory); j++ {
 		currentPoll := currentVoter.VoteHistory[j]
 		if currentPoll.PollID == pollId {
 			index = j
 		}
 	}
+	if index == -1 {
+		return ErrVoterPollNotFound
+	}
 
 	currentVoter.VoteHistory = append(currentVoter.VoteHistory[:index], currentVoter.VoteHistory[index+1:]...)
 
-	redisKey := redisKeyFromId(int(voterId))
+	redisKey := lst.redisKeyFromId(int(voterId))
 	if _, err := lst.jsonHelper.JSONSet(redisKey, ".", currentVoter); err != nil {
 		return err
 	}
+	lst.publishEvent(events.VoterUpdated, currentVoter.VoterId, currentVoter)
 
 	return nil
 }
+
+/*
+Removes the poll with PollID = :id from the history of every voter, used when
+the poll itself is deleted.  Returns the number of voters that were updated
+*/
+func (lst *VoterList) DeletePollFromHistories(pollId uint) (int, error) {
+
+	voterList, err := lst.GetAllVoters()
+	if err != nil {
+		return 0, err
+	}
+
+	numUpdated := 0
+	for _, voter := range voterList {
+		if !removePollFromHistory(&voter, pollId) {
+			continue
+		}
+
+		redisKey := lst.redisKeyFromId(int(voter.VoterId))
+		if _, err := lst.jsonHelper.JSONSet(redisKey, ".", voter); err != nil {
+			return numUpdated, err
+		}
+		lst.publishEvent(events.VoterUpdated, voter.VoterId, voter)
+		numUpdated++
+	}
+
+	return numUpdated, nil
+}
+
+// Helper to drop a poll from a voter's history, reports whether the
+// history changed
+func removePollFromHistory(voter *Voter, pollId uint) bool {
+	history := make([]voterPoll, 0, len(voter.VoteHistory))
+	for _, entry := range voter.VoteHistory {
+		if entry.PollID != pollId {
+			history = append(history, entry)
+		}
+	}
+	if len(history) == len(voter.VoteHistory) {
+		return false
+	}
+
+	voter.VoteHistory = history
+	return true
+}
@@ -1,4 +1,4 @@
 #!/bin/bash
-curl -d '{ "id": 1, "firstname": "John", "lastname": "Doe", "votehistory": [{"pollid": 59231, "votedate": "2021-08-15T14:30:45.00Z"}] }' -H "Content-Type: application/json" -X POST http://localhost:1081/voters/1
-curl -d '{ "id": 2, "firstname": "Jane", "lastname": "Schmoe", "votehistory": [{"pollid": 12342, "votedate": "2021-08-16T14:30:45.00Z"}] }' -H "Content-Type: application/json" -X POST http://localhost:1081/voters/2
-curl -d '{ "id": 3, "firstname": "Bob", "lastname": "Ross", "votehistory": [{"pollid": 54323, "votedate": "2021-08-17T14:30:45.00Z"}] }' -H "Content-Type: application/json" -X POST http://localhost:1081/voters/3
\ No newline at end of file
+curl -d '{ "id": 1, "firstname": "John", "lastname": "Doe", "votehistory": [] }' -H "Content-Type: application/json" -X POST http://localhost:1081/voters/1
+curl -d '{ "id": 2, "firstname": "Jane", "lastname": "Schmoe", "votehistory": [] }' -H "Content-Type: application/json" -X POST http://localhost:1081/voters/2
+curl -d '{ "id": 3, "firstname": "Bob", "lastname": "Ross", "votehistory": [] }' -H "Content-Type: application/json" -X POST http://localhost:1081/voters/3
\ No newline at end of file
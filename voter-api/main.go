@@ -1,73 +1,107 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
-
+	"os/signal"
+	"syscall"
+	"time"
+
+	"drexel.edu/common/config"
+	"drexel.edu/common/logging"
+	"drexel.edu/common/tracing"
+	"drexel.edu/common/web"
 	"drexel.edu/voter-api/api"
+	"drexel.edu/voter-api/db"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-// Global variables to hold the command line flags to drive the todo CLI
-// application
-var (
-	hostFlag string
-	portFlag uint
-)
-
-// processCmdLineFlags parses the command line flags for our CLI
-//
-// TODO: This function uses the flag package to parse the command line
-//		 flags.  The flag package is not very flexible and can lead to
-//		 some confusing code.
-
-//			 REQUIRED:     Study the code below, and make sure you understand
-//						   how it works.  Go online and readup on how the
-//						   flag package works.  Then, write a nice comment
-//				  		   block to document this function that highights that
-//						   you understand how it works.
-//
-//			 EXTRA CREDIT: The best CLI and command line processor for
-//						   go is called Cobra.  Refactor this function to
-//						   use it.  See github.com/spf13/cobra for information
-//						   on how to use it.
-//
-//	 YOUR ANSWER: <GOES HERE>
-func processCmdLineFlags() {
-
-	//Note some networking lingo, some frameworks start the server on localhost
-	//this is a local-only interface and is fine for testing but its not accessible
-	//from other machines.  To make the server accessible from other machines, we
-	//need to listen on an interface, that could be an IP address, but modern
-	//cloud servers may have multiple network interfaces for scale.  With TCP/IP
-	//the address 0.0.0.0 instructs the network stack to listen on all interfaces
-	//We set this up as a flag so that we can overwrite it on the command line if
-	//needed
-	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
-	flag.UintVar(&portFlag, "p", 1080, "Default Port")
-
-	flag.Parse()
+// What sets this service's configuration apart from the others
+var service = config.Service{
+	Keeps:      "voters",
+	SQLitePath: db.DefaultSQLitePath,
+	Calls: map[string]string{
+		config.VotesAPI: "http://localhost:1082",
+	},
 }
 
 // main is the entry point for our todo API application.  It processes
 // the command line flags and then uses the db package to perform the
 // requested operation
 func main() {
-	processCmdLineFlags()
-	r := gin.Default()
+	//Settings come from the defaults, an optional config file, the
+	//environment and the command line, in that order
+	cfg, err := config.Load(service, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := logging.Setup(cfg.LogFormat, cfg.LogLevel); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if cfg.File != "" {
+		slog.Info("Init", "configFile", cfg.File)
+	}
+	slog.Info("Init", "votesAPIURL", cfg.VotesAPIURL)
+	slog.Info("Init", "store", cfg.Store)
+	switch cfg.Store {
+	case db.StoreRedis:
+		slog.Info("Init", "redis", cfg.Redis.Addr, "redisDB", cfg.Redis.DB, "redisTLS", cfg.Redis.TLS, "redisKeyPrefix", cfg.Redis.KeyPrefix)
+	case db.StoreSQLite:
+		slog.Info("Init", "sqlitePath", cfg.SQLitePath)
+	}
+	slog.Info("Init", "traces", cfg.Traces)
+
+	//gin's own request logger is replaced by AccessLog, which logs in the
+	//same format as everything else
+	r := gin.New()
+	r.Use(gin.Recovery())
 	r.Use(cors.Default())
+	r.Use(api.Tracing())
+	r.Use(web.RequestID())
+	r.Use(web.AccessLog())
+	r.Use(api.Metrics())
 
-	apiHandler, err := api.New()
+	shutdownTracing, err := tracing.Setup(api.ServiceName, cfg.Traces, cfg.TracesFile)
+	if err != nil {
+		panic(err)
+	}
+
+	//Stop on SIGINT from a terminal or SIGTERM from docker
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	apiHandler, err := api.New(cfg.Store, cfg.Redis, cfg.SQLitePath, cfg.VotesAPIURL)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	r.Use(apiHandler.CountRequests())
 
 	r.GET("/voters", apiHandler.GetAllVoterResources)
 
+	r.GET("/voters/search", apiHandler.SearchVoters)
 	r.GET("/voters/:id", apiHandler.GetSingleVoterResource)
+	// Create a voter under a server assigned id
+	r.POST("/voters", apiHandler.CreateVoter)
 	// Create a voters resource with id = :id, initialize the polls slice to an
 	// empty slice
 	r.POST("/voters/:id", apiHandler.AddVoter)
@@ -85,8 +119,15 @@ func main() {
 	// add pollid 3 to the NEW voter 22 resource. If not, follow above
 	r.POST("/voters/:id/polls/:pollid", apiHandler.AddVoterPollData)
 
+	// Liveness and readiness checks, /voters/health is kept for older
+	// clients
+	r.GET("/healthz", apiHandler.HealthCheck)
+	r.GET("/readyz", apiHandler.ReadinessCheck)
 	r.GET("/voters/health", apiHandler.HealthCheck)
 
+	// Prometheus metrics
+	r.GET("/metrics", api.MetricsHandler())
+
 	// Extra Credit
 	r.DELETE("/voters", apiHandler.DeleteAllVoters)
 
@@ -94,8 +135,59 @@ func main() {
 
 	r.DELETE("/voters/:id/polls/:pollid", apiHandler.DeletePoll)
 
+	// Remove poll :pollid from every voter's history, used when the poll
+	// itself is deleted
+	r.DELETE("/voters/polls/:pollid", apiHandler.DeletePollFromHistories)
+
 	r.PUT("/voters", apiHandler.UpdateVoter)
 
-	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
-	r.Run(serverPath)
+	serverPath := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	srv := &http.Server{
+		Addr:         serverPath,
+		Handler:      r,
+		ReadTimeout:  cfg.Timeouts.Read,
+		WriteTimeout: cfg.Timeouts.Write,
+		IdleTimeout:  cfg.Timeouts.Idle,
+	}
+
+	err = serve(ctx, srv, cfg.Timeouts.Shutdown)
+	if err != nil {
+		slog.Error("Error running server", "error", err)
+	}
+	stop()
+
+	//Only release the store and flush traces once nothing can use them
+	if err := apiHandler.Close(); err != nil {
+		slog.Error("Error closing store", "error", err)
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// serve runs srv until ctx is cancelled, then stops taking new
+// connections and gives the requests in flight up to shutdownTimeout to
+// finish
+func serve(ctx context.Context, srv *http.Server, shutdownTimeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down, draining requests", "timeout", shutdownTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serviceVersion is reported by the health endpoints
+const serviceVersion = "1.0.0"
+
+// How long the readiness check waits for another service to answer
+const dependencyCheckTimeout = 2 * time.Second
+
+// healthStats counts the requests the service has handled since it
+// started, for the health endpoints
+type healthStats struct {
+	startedAt time.Time
+	requests  atomic.Uint64
+	errors    atomic.Uint64
+}
+
+func newHealthStats() *healthStats {
+	return &healthStats{startedAt: time.Now()}
+}
+
+// Helper that returns middleware counting every request, and every
+// request the service failed with a 5xx
+func (h *healthStats) countRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		h.requests.Add(1)
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			h.errors.Add(1)
+		}
+	}
+}
+
+// Helper to build the body of a liveness response
+func (h *healthStats) summary() gin.H {
+	return gin.H{
+		"status":             "ok",
+		"version":            serviceVersion,
+		"uptime":             int64(time.Since(h.startedAt).Seconds()),
+		"requests_processed": h.requests.Load(),
+		"errors_encountered": h.errors.Load(),
+	}
+}
+
+// dependencyCheck is the result of checking one thing the service needs
+// in order to handle requests
+type dependencyCheck struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Helper to run and time a check
+func runCheck(name string, check func() error) dependencyCheck {
+	start := time.Now()
+	err := check()
+	result := dependencyCheck{
+		Name:      name,
+		Status:    "ok",
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = "unavailable"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Helper to send a readiness response, 200 if every check passed and 503
+// otherwise
+func readinessResponse(c *gin.Context, checks ...dependencyCheck) {
+	status, body := http.StatusOK, "ok"
+	for _, check := range checks {
+		if check.Status != "ok" {
+			status, body = http.StatusServiceUnavailable, "unavailable"
+		}
+	}
+	c.JSON(status, gin.H{"status": body, "checks": checks})
+}
+
+// CountRequests returns middleware that feeds the counters reported by
+// HealthCheck, it has to be installed before the routes
+func (v *VoteAPI) CountRequests() gin.HandlerFunc {
+	return v.health.countRequests()
+}
+
+// implementation for GET /healthz
+// reports that the process is up, without checking anything it depends on
+func (v *VoteAPI) HealthCheck(c *gin.Context) {
+	c.JSON(http.StatusOK, v.health.summary())
+}
+
+// implementation for GET /readyz
+// reports whether the store, the voter API and the poll API can be
+// reached.  Votes can't be cast without all three
+func (v *VoteAPI) ReadinessCheck(c *gin.Context) {
+	readinessResponse(c,
+		runCheck(v.storeKind, v.db.Ping),
+		runCheck("voter-api", func() error { return v.pingService(v.voterAPIURL) }),
+		runCheck("poll-api", func() error { return v.pingService(v.pollAPIURL) }),
+	)
+}
+
+// Helper to check that another service is up through its liveness
+// endpoint
+func (v *VoteAPI) pingService(baseURL string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dependencyCheckTimeout)
+	defer cancel()
+
+	resp, err := v.apiClient.R().SetContext(ctx).Get(baseURL + "/healthz")
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("%s/healthz returned %d", baseURL, resp.StatusCode())
+	}
+	return nil
+}
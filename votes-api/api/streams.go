@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"drexel.edu/votes-api/db"
+)
+
+// resultsHub reads the domain event stream on behalf of every open results
+// stream, so however many clients are watching there is only ever one
+// blocking read on the store.  Each subscriber is told when an event about
+// its poll arrives.  The read loop only runs while someone is subscribed
+type resultsHub struct {
+	db db.VoteStore
+	//The read loop stops when this is cancelled
+	ctx context.Context
+
+	mu      sync.Mutex
+	subs    map[*resultsSubscription]struct{}
+	running bool
+}
+
+// resultsSubscription is one results stream's view of the hub.  changed
+// gets a value when the poll may have new results, several events in a
+// row are folded into one.  lost is closed if the event stream can't be
+// read any more, and the subscription is dropped
+type resultsSubscription struct {
+	pollId  uint
+	changed chan struct{}
+	lost    chan struct{}
+}
+
+func newResultsHub(ctx context.Context, store db.VoteStore) *resultsHub {
+	return &resultsHub{
+		db:   store,
+		ctx:  ctx,
+		subs: make(map[*resultsSubscription]struct{}),
+	}
+}
+
+// Helper to start watching for events about a poll.  Any event published
+// after subscribe returns is passed on, so results tallied afterwards
+// can't miss a vote.  Call unsubscribe once the stream ends
+func (h *resultsHub) subscribe(pollId uint) (*resultsSubscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.running {
+		lastID, err := h.db.LatestEventID()
+		if err != nil {
+			return nil, err
+		}
+		h.running = true
+		go h.run(lastID)
+	}
+
+	sub := &resultsSubscription{
+		pollId:  pollId,
+		changed: make(chan struct{}, 1),
+		lost:    make(chan struct{}),
+	}
+	h.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+func (h *resultsHub) unsubscribe(sub *resultsSubscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subs, sub)
+}
+
+// Helper that reads the event stream from lastID and tells the
+// subscribers about their polls, until nobody is subscribed, the hub is
+// shut down or the stream can't be read
+func (h *resultsHub) run(lastID string) {
+	for {
+		events, nextID, err := h.db.ReadEvents(h.ctx, lastID, resultsStreamBlockTime)
+
+		h.mu.Lock()
+		if len(h.subs) == 0 || h.ctx.Err() != nil {
+			h.running = false
+			h.mu.Unlock()
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(h.ctx, "Error reading event stream", "error", err)
+			for sub := range h.subs {
+				close(sub.lost)
+				delete(h.subs, sub)
+			}
+			h.running = false
+			h.mu.Unlock()
+			return
+		}
+
+		for _, event := range events {
+			eventPollId, ok := db.PollIDFromEvent(event)
+			if !ok {
+				continue
+			}
+			for sub := range h.subs {
+				if sub.pollId != eventPollId {
+					continue
+				}
+				//The subscriber hasn't caught up with the last change
+				//yet, and will tally everything when it does
+				select {
+				case sub.changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+		h.mu.Unlock()
+
+		lastID = nextID
+	}
+}
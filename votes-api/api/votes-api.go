@@ -2,90 +2,85 @@ package api
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"time"
 
 	"drexel.edu/votes-api/db"
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis/v8"
-	"github.com/nitishm/go-rejson/v4"
 
+	"drexel.edu/common/storage"
+	"drexel.edu/common/web"
 	"github.com/go-resty/resty/v2"
 )
 
-type cache struct {
-	client  *redis.Client
-	helper  *rejson.Handler
-	context context.Context
-}
+// How long a results stream waits for new votes before sending a
+// keepalive to the client, which is also how long the shared read of the
+// event stream blocks
+const resultsStreamBlockTime = 15 * time.Second
 
 type VoteAPI struct {
-	cache
 	voterAPIURL string
 	pollAPIURL  string
 	apiClient   *resty.Client
-	db          *db.VoteList
+	db          db.VoteStore
+	storeKind   string
+	health      *healthStats
+
+	//Cancelled by EndStreams to end every open results stream
+	streams    context.Context
+	endStreams context.CancelFunc
+	results    *resultsHub
 }
 
-func NewVoteAPI(location string, voterAPIURL string, pollAPIURL string) (*VoteAPI, error) {
-	apiClient := resty.New()
-	dbHandler, err := db.NewVoteList()
-
-	//Connect to redis.  Other options can be provided, but the
-	//defaults are OK
-	client := redis.NewClient(&redis.Options{
-		Addr: location,
-	})
-
-	//We use this context to coordinate betwen our go code and
-	//the redis operaitons
-	ctx := context.Background()
-
-	//This is the reccomended way to ensure that our redis connection
-	//is working
-	err2 := client.Ping(ctx).Err()
-	if err2 != nil {
-		log.Println("Error connecting to redis" + err.Error())
-		return nil, err2
+func NewVoteAPI(store string, redisCfg db.RedisConfig, sqlitePath string, voterAPIURL string, pollAPIURL string) (*VoteAPI, error) {
+	apiClient := instrumentClient(traceClient(web.ForwardRequestID(resty.New())))
+	dbHandler, err := db.NewStore(store, redisCfg, sqlitePath)
+	if err != nil {
+		return nil, err
 	}
 
-	jsonHelper := rejson.NewReJSONHandler()
-	jsonHelper.SetGoRedisClientWithContext(ctx, client)
+	streams, endStreams := context.WithCancel(context.Background())
 
 	//Return a pointer to a new ToDo struct
 	return &VoteAPI{
-		cache: cache{
-			client:  client,
-			helper:  jsonHelper,
-			context: ctx,
-		},
 		voterAPIURL: voterAPIURL,
 		pollAPIURL:  pollAPIURL,
 		db:          dbHandler,
 		apiClient:   apiClient,
+		storeKind:   store,
+		health:      newHealthStats(),
+		streams:     streams,
+		endStreams:  endStreams,
+		results:     newResultsHub(streams, dbHandler),
 	}, nil
 }
 
+// EndStreams ends every open results stream.  Streams only end when the
+// client hangs up, so the server can't drain its requests until this is
+// called
+func (v *VoteAPI) EndStreams() {
+	v.endStreams()
+}
+
+// Close releases the store.  Call it once the server has stopped handling
+// requests
+func (v *VoteAPI) Close() error {
+	return v.db.Close()
+}
+
 func (v *VoteAPI) GetVote(c *gin.Context) {
 	voteId := c.Param("id")
 	if voteId == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No vote ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No vote ID provided")
 		return
 	}
 
-	cacheKey := "vote:" + voteId
-	v1Bytes, err := v.helper.JSONGet(cacheKey, ".")
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Could not find vote in cache with id=" + cacheKey})
-		return
-	}
-
-	var v1 db.Vote
-	err = json.Unmarshal(v1Bytes.([]byte), &v1)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "cached data seems to be wrong type"})
+	v1, ok := v.voteFromParam(c, voteId)
+	if !ok {
 		return
 	}
 
@@ -95,28 +90,18 @@ func (v *VoteAPI) GetVote(c *gin.Context) {
 func (v *VoteAPI) GetVoterByVote(c *gin.Context) {
 	voteId := c.Param("id")
 	if voteId == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No vote ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No vote ID provided")
 		return
 	}
 
-	cacheKey := "vote:" + voteId
-	var v1 db.Vote
-	err := v.getItemFromRedis(cacheKey, &v1)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Could not find vote in cache with id=" + cacheKey})
+	v1, ok := v.voteFromParam(c, voteId)
+	if !ok {
 		return
 	}
 
-	voterLocation := v1.VoterID
-
-	voterURL := v.voterAPIURL + "/voters/" + strconv.FormatUint(uint64(voterLocation), 10)
-	log.Println(voterURL)
-	var voter db.Voter
-
-	_, err = v.apiClient.R().SetResult(&voter).Get(voterURL)
+	voter, status, err := v.fetchVoter(c.Request.Context(), v1.VoterID)
 	if err != nil {
-		emsg := "Could not get voter from API: (" + voterURL + ")" + err.Error()
-		c.JSON(http.StatusNotFound, gin.H{"error": emsg})
+		web.AbortWithError(c, status, err.Error())
 		return
 	}
 
@@ -128,29 +113,18 @@ func (v *VoteAPI) GetPollByVote(c *gin.Context) {
 
 	voteId := c.Param("id")
 	if voteId == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No vote ID provided"})
+		web.AbortWithError(c, http.StatusBadRequest, "No vote ID provided")
 		return
 	}
 
-	cacheKey := "vote:" + voteId
-	var v1 db.Vote
-	err := v.getItemFromRedis(cacheKey, &v1)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Could not find vote in cache with id=" + cacheKey})
+	v1, ok := v.voteFromParam(c, voteId)
+	if !ok {
 		return
 	}
 
-	pollLocation := v1.PollID
-
-	// pollURL := v.pollAPIURL + "/polls/" + string(pollLocation)
-	pollURL := v.pollAPIURL + "/polls/" + strconv.FormatUint(uint64(pollLocation), 10)
-	log.Println(pollURL)
-	var poll db.Poll
-
-	_, err = v.apiClient.R().SetResult(&poll).Get(pollURL)
+	poll, status, err := v.fetchPoll(c.Request.Context(), v1.PollID)
 	if err != nil {
-		emsg := "Could not get voter from API: (" + pollURL + ")" + err.Error()
-		c.JSON(http.StatusNotFound, gin.H{"error": emsg})
+		web.AbortWithError(c, status, err.Error())
 		return
 	}
 
@@ -158,63 +132,392 @@ func (v *VoteAPI) GetPollByVote(c *gin.Context) {
 }
 
 func (v *VoteAPI) GetAllVotes(c *gin.Context) {
-	var voteList []db.Vote
-	var voteItem db.Vote
-
-	//Lets query redis for all of the items
-	votePattern := "vote:*"
-	voteKs, _ := v.client.Keys(v.context, votePattern).Result()
-	for _, key := range voteKs {
-		err := v.getItemFromRedis(key, &voteItem)
+	cursor, limit, paged, ok := web.ParsePage(c)
+	if !ok {
+		return
+	}
+
+	if paged {
+		voteList, next, err := v.store(c).GetVotesPage(cursor, limit)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find reading list in cache with id=" + key})
+			slog.ErrorContext(c.Request.Context(), "Error Getting Votes Page", "error", err)
+			web.AbortWithStoreError(c, err)
 			return
 		}
-		voteList = append(voteList, voteItem)
+		c.JSON(http.StatusOK, web.PageResponse{Items: voteList, NextCursor: next})
+		return
+	}
+
+	voteList, err := v.store(c).GetAllVotes()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error Getting All Votes", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
 	}
 
 	c.JSON(http.StatusOK, voteList)
 }
 
-// Helper to return a ToDoItem from redis provided a key
-func (v *VoteAPI) getItemFromRedis(key string, rl *db.Vote) error {
+// implementation for GET /polls/:id/results
+// returns the number and percentage of votes for every option of a poll
+func (v *VoteAPI) GetPollResults(c *gin.Context) {
+	idS := c.Param("id")
+	if idS == "" {
+		web.AbortWithError(c, http.StatusBadRequest, "No poll ID provided")
+		return
+	}
+	id64, err := strconv.ParseUint(idS, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to uint64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid poll ID")
+		return
+	}
 
-	//Lets query redis for the item, note we can return parts of the
-	//json structure, the second parameter "." means return the entire
-	//json structure
-	itemObject, err := v.helper.JSONGet(key, ".")
+	results, status, err := v.pollResults(c.Request.Context(), uint(id64))
 	if err != nil {
-		return err
+		web.AbortWithError(c, status, err.Error())
+		return
 	}
 
-	//JSONGet returns an "any" object, or empty interface,
-	//we need to convert it to a byte array, which is the
-	//underlying type of the object, then we can unmarshal
-	//it into our ToDoItem struct
-	err = json.Unmarshal(itemObject.([]byte), rl)
+	c.JSON(http.StatusOK, results)
+}
+
+// implementation for GET /polls/:id/results/stream
+// streams the poll results as Server-Sent Events.  The current tally is
+// sent right away and a new one every time a vote on the poll is cast or
+// deleted
+func (v *VoteAPI) StreamPollResults(c *gin.Context) {
+	idS := c.Param("id")
+	if idS == "" {
+		web.AbortWithError(c, http.StatusBadRequest, "No poll ID provided")
+		return
+	}
+	id64, err := strconv.ParseUint(idS, 10, 32)
 	if err != nil {
-		return err
+		slog.WarnContext(c.Request.Context(), "Error converting id to uint64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid poll ID")
+		return
 	}
+	pollId := uint(id64)
 
-	return nil
+	//Start listening for votes before taking the first tally, so no vote
+	//can slip in between the two
+	sub, err := v.results.subscribe(pollId)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error reading event stream", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+	defer v.results.unsubscribe(sub)
+
+	results, status, err := v.pollResults(c.Request.Context(), pollId)
+	if err != nil {
+		web.AbortWithError(c, status, err.Error())
+		return
+	}
+
+	//A stream lasts until the client hangs up, so the server's write
+	//timeout must not cut it off
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		slog.WarnContext(c.Request.Context(), "Error clearing write deadline for stream", "error", err)
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.SSEvent("results", results)
+	c.Writer.Flush()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	stopWatching := context.AfterFunc(v.streams, cancel)
+	defer stopWatching()
+
+	keepalive := time.NewTicker(resultsStreamBlockTime)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			//The client went away or the server is shutting down, either
+			//way the client can reconnect to pick up where it was
+			return
+		case <-sub.lost:
+			c.SSEvent("error", gin.H{"error": "Lost connection to the event stream"})
+			c.Writer.Flush()
+			return
+		case <-keepalive.C:
+			//An SSE comment keeps proxies from closing an idle stream
+			fmt.Fprint(c.Writer, ": keepalive\n\n")
+			c.Writer.Flush()
+		case <-sub.changed:
+			results, _, err := v.pollResults(ctx, pollId)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.ErrorContext(c.Request.Context(), "Error refreshing poll results", "error", err)
+				c.SSEvent("error", gin.H{"error": err.Error()})
+				c.Writer.Flush()
+				return
+			}
+			c.SSEvent("results", results)
+			c.Writer.Flush()
+		}
+	}
+}
+
+// Helper to tally the votes for a poll.  If an error is returned, the
+// status is the HTTP status code that should be reported to the caller
+func (v *VoteAPI) pollResults(ctx context.Context, pollId uint) (db.PollResults, int, error) {
+	//The option text lives in the poll API, so we need the poll
+	//before we can label the tally
+	poll, status, err := v.fetchPoll(ctx, pollId)
+	if err != nil {
+		return db.PollResults{}, status, err
+	}
+
+	//Once a poll closes the poll API freezes its results, and those are
+	//the results from then on
+	if poll.FinalResults != nil {
+		return *poll.FinalResults, http.StatusOK, nil
+	}
+
+	votes, err := v.db.WithContext(ctx).GetVotesForPoll(poll.PollID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting votes for poll", "error", err)
+		return db.PollResults{}, http.StatusInternalServerError, errors.New("Could not load votes for poll")
+	}
+
+	return db.TallyVotes(poll, votes), http.StatusOK, nil
+}
+
+// Helper to fetch a poll from the poll API.  If an error is returned, the
+// status is the HTTP status code that should be reported to the caller
+func (v *VoteAPI) fetchPoll(ctx context.Context, pollId uint) (db.Poll, int, error) {
+	pollURL := v.pollAPIURL + "/polls/" + strconv.FormatUint(uint64(pollId), 10)
+	var poll db.Poll
+
+	resp, err := v.apiClient.R().SetContext(detach(ctx)).SetResult(&poll).Get(pollURL)
+	if err != nil {
+		return db.Poll{}, http.StatusBadGateway, errors.New("Could not get poll from API: (" + pollURL + ")" + err.Error())
+	}
+	if resp.StatusCode() == http.StatusNotFound {
+		return db.Poll{}, http.StatusNotFound, fmt.Errorf("Could not find poll with id=%d", pollId)
+	}
+	if resp.IsError() {
+		return db.Poll{}, http.StatusBadGateway, fmt.Errorf("Poll API returned %s for (%s)", resp.Status(), pollURL)
+	}
+
+	return poll, http.StatusOK, nil
+}
+
+// Helper to fetch a voter from the voter API.  If an error is returned, the
+// status is the HTTP status code that should be reported to the caller
+func (v *VoteAPI) fetchVoter(ctx context.Context, voterId uint) (db.Voter, int, error) {
+	voterURL := v.voterAPIURL + "/voters/" + strconv.FormatUint(uint64(voterId), 10)
+	var voter db.Voter
+
+	resp, err := v.apiClient.R().SetContext(detach(ctx)).SetResult(&voter).Get(voterURL)
+	if err != nil {
+		return db.Voter{}, http.StatusBadGateway, errors.New("Could not get voter from API: (" + voterURL + ")" + err.Error())
+	}
+	if resp.StatusCode() == http.StatusNotFound {
+		return db.Voter{}, http.StatusNotFound, fmt.Errorf("Could not find voter with id=%d", voterId)
+	}
+	if resp.IsError() {
+		return db.Voter{}, http.StatusBadGateway, fmt.Errorf("Voter API returned %s for (%s)", resp.Status(), voterURL)
+	}
+
+	return voter, http.StatusOK, nil
+}
+
+// Helper to look up the vote named in the path, writing the error
+// response when there isn't one
+func (v *VoteAPI) voteFromParam(c *gin.Context, voteId string) (db.Vote, bool) {
+	id64, err := strconv.ParseUint(voteId, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to uint64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid vote ID")
+		return db.Vote{}, false
+	}
+
+	vote, err := v.store(c).GetSingleVoterResource(uint(id64))
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Item not found", "error", err)
+		web.AbortWithStoreError(c, err)
+		return db.Vote{}, false
+	}
+
+	return vote, true
 }
 
 // implementation for DELETE /todo
 // deletes all todos
 func (v *VoteAPI) DeleteAllVotes(c *gin.Context) {
 
-	if err := v.db.DeleteAll(); err != nil {
-		log.Println("Error deleting all items: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	if err := v.store(c).DeleteAll(); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error deleting all items", "error", err)
+		web.AbortWithStoreError(c, err)
 		return
 	}
 
 	c.Status(http.StatusOK)
 }
 
+// implementation for DELETE /votes/:id
+// deletes a vote and removes the poll from the voter's history
+func (v *VoteAPI) DeleteVote(c *gin.Context) {
+	idS := c.Param("id")
+	if idS == "" {
+		web.AbortWithError(c, http.StatusBadRequest, "No vote ID provided")
+		return
+	}
+	id64, err := strconv.ParseUint(idS, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to uint64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid vote ID")
+		return
+	}
+
+	vote, err := v.store(c).GetSingleVoterResource(uint(id64))
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Item not found", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	if err := v.store(c).DeleteVote(vote.VoteID); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error deleting item", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	//The vote is gone either way, but let the caller know if the voter
+	//history could not be brought back in line
+	if err := v.removeVoterHistory(c.Request.Context(), vote); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error removing voter history", "error", err)
+		web.AbortWithError(c, http.StatusBadGateway, "Vote deleted but voter history was not updated: "+err.Error())
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// implementation for GET /polls/:id/votes
+// lists the votes cast on a poll, a page at a time if asked to
+func (v *VoteAPI) GetPollVotes(c *gin.Context) {
+	store := v.store(c)
+	v.listVotesFor(c, "poll", store.GetVotesForPoll, store.GetVotesForPollPage)
+}
+
+// implementation for GET /voters/:id/votes
+// lists the votes cast by a voter, a page at a time if asked to
+func (v *VoteAPI) GetVoterVotes(c *gin.Context) {
+	store := v.store(c)
+	v.listVotesFor(c, "voter", store.GetVotesForVoter, store.GetVotesForVoterPage)
+}
+
+// Helper shared by the poll and voter vote listings.  all and page look up
+// the votes for the id in the URL, either all at once or one page at a time
+func (v *VoteAPI) listVotesFor(c *gin.Context, noun string,
+	all func(uint) ([]db.Vote, error),
+	page func(uint, string, int) ([]db.Vote, string, error)) {
+
+	idS := c.Param("id")
+	id64, err := strconv.ParseUint(idS, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to uint64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid "+noun+" ID")
+		return
+	}
+
+	cursor, limit, paged, ok := web.ParsePage(c)
+	if !ok {
+		return
+	}
+
+	if paged {
+		voteList, next, err := page(uint(id64), cursor, limit)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error getting votes", noun+"Id", id64, "error", err)
+			web.AbortWithStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, web.PageResponse{Items: voteList, NextCursor: next})
+		return
+	}
+
+	voteList, err := all(uint(id64))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error getting votes", noun+"Id", id64, "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, voteList)
+}
+
+// implementation for DELETE /polls/:id/votes
+// deletes every vote cast on a poll, used when a poll is deleted
+func (v *VoteAPI) DeletePollVotes(c *gin.Context) {
+	idS := c.Param("id")
+	if idS == "" {
+		web.AbortWithError(c, http.StatusBadRequest, "No poll ID provided")
+		return
+	}
+	id64, err := strconv.ParseUint(idS, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to uint64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid poll ID")
+		return
+	}
+
+	numDeleted, err := v.store(c).DeleteVotesForPoll(uint(id64))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error deleting votes for poll", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": numDeleted})
+}
+
+// implementation for DELETE /voters/:id/votes
+// deletes every vote cast by a voter, used when a voter is deleted
+func (v *VoteAPI) DeleteVoterVotes(c *gin.Context) {
+	idS := c.Param("id")
+	if idS == "" {
+		web.AbortWithError(c, http.StatusBadRequest, "No voter ID provided")
+		return
+	}
+	id64, err := strconv.ParseUint(idS, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to uint64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid voter ID")
+		return
+	}
+
+	numDeleted, err := v.store(c).DeleteVotesForVoter(uint(id64))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error deleting votes for voter", "error", err)
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": numDeleted})
+}
+
 // implementation for POST /todo
 // adds a new todo
 func (v *VoteAPI) AddVote(c *gin.Context) {
+	idS := c.Param("id")
+	id64, err := strconv.ParseUint(idS, 10, 32)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Error converting id to uint64", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid vote ID")
+		return
+	}
+
 	var vote db.Vote
 
 	//With HTTP based APIs, a POST request will usually
@@ -230,16 +533,144 @@ func (v *VoteAPI) AddVote(c *gin.Context) {
 	//the struct we are binding to.
 
 	if err := c.ShouldBindJSON(&vote); err != nil {
-		log.Println("Error binding JSON: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		slog.WarnContext(c.Request.Context(), "Error binding JSON", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
 		return
 	}
 
-	if err := v.db.AddVote(vote); err != nil {
-		log.Println("Error adding item: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	//The body may leave out the id, but it can't name a different vote
+	if vote.VoteID == 0 {
+		vote.VoteID = uint(id64)
+	}
+	if vote.VoteID != uint(id64) {
+		web.AbortWithError(c, http.StatusBadRequest, "Vote ID in body does not match the URL")
+		return
+	}
+
+	v.castVote(c, vote, false)
+}
+
+// implementation for POST /votes
+// the server picks the vote id and returns it in the Location header
+func (v *VoteAPI) CreateVote(c *gin.Context) {
+	var vote db.Vote
+	if err := c.ShouldBindJSON(&vote); err != nil {
+		slog.WarnContext(c.Request.Context(), "Error binding JSON", "error", err)
+		web.AbortWithError(c, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	if vote.VoteID != 0 {
+		web.AbortWithError(c, http.StatusBadRequest, "Vote IDs are assigned by the server, use POST /votes/:id to choose one")
+		return
+	}
+
+	v.castVote(c, vote, true)
+}
+
+// Helper that validates and stores a vote for both POST routes.  When
+// allocate is set the vote gets a server assigned id
+func (v *VoteAPI) castVote(c *gin.Context, vote db.Vote, allocate bool) {
+	//Before accepting the vote make sure it refers to a real voter, a
+	//real poll and one of the options on that poll
+	if _, status, err := v.fetchVoter(c.Request.Context(), vote.VoterID); err != nil {
+		slog.WarnContext(c.Request.Context(), "Rejecting vote", "error", err)
+		votesRejected.WithLabelValues(rejectVoter).Inc()
+		web.AbortWithError(c, status, err.Error())
+		return
+	}
+
+	poll, status, err := v.fetchPoll(c.Request.Context(), vote.PollID)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Rejecting vote", "error", err)
+		votesRejected.WithLabelValues(rejectPoll).Inc()
+		web.AbortWithError(c, status, err.Error())
+		return
+	}
+
+	if !poll.AcceptingVotes(time.Now()) {
+		emsg := fmt.Sprintf("Poll id=%d is not open for voting", vote.PollID)
+		slog.WarnContext(c.Request.Context(), "Rejecting vote", "error", emsg)
+		votesRejected.WithLabelValues(rejectPollNotOpen).Inc()
+		web.AbortWithError(c, http.StatusConflict, emsg)
+		return
+	}
+
+	if !poll.HasOption(vote.VoteValue) {
+		emsg := fmt.Sprintf("Vote value %d is not an active option on poll id=%d", vote.VoteValue, vote.PollID)
+		slog.WarnContext(c.Request.Context(), "Rejecting vote", "error", emsg)
+		votesRejected.WithLabelValues(rejectInvalidOption).Inc()
+		web.AbortWithError(c, http.StatusUnprocessableEntity, emsg)
+		return
+	}
+
+	if allocate {
+		err = v.store(c).CreateVote(&vote)
+	} else {
+		err = v.store(c).AddVote(vote)
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error adding item", "error", err)
+		if errors.Is(err, storage.ErrConflict) {
+			votesRejected.WithLabelValues(rejectDuplicate).Inc()
+		} else {
+			votesRejected.WithLabelValues(rejectStore).Inc()
+		}
+		web.AbortWithStoreError(c, err)
+		return
+	}
+
+	//The voter API keeps its own copy of each voter's history.  If we
+	//can't record the vote there, undo the vote so the two stores don't
+	//drift apart
+	if err := v.recordVoterHistory(c.Request.Context(), vote); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error recording voter history, rolling back vote", "error", err)
+		if rbErr := v.store(c).DeleteVote(vote.VoteID); rbErr != nil {
+			slog.ErrorContext(c.Request.Context(), "Error rolling back vote", "voteId", vote.VoteID, "error", rbErr)
+		}
+		votesRejected.WithLabelValues(rejectVoterHistory).Inc()
+		web.AbortWithError(c, http.StatusBadGateway, "Could not record vote in voter history: "+err.Error())
 		return
 	}
+	votesCast.WithLabelValues(strconv.FormatUint(uint64(vote.PollID), 10)).Inc()
 
+	if allocate {
+		c.Header("Location", fmt.Sprintf("/votes/%d", vote.VoteID))
+		c.JSON(http.StatusCreated, vote)
+		return
+	}
 	c.JSON(http.StatusOK, vote)
 }
+
+// Helper to add the poll a vote was cast on to the voter's history
+// through POST /voters/:id/polls/:pollid on the voter API
+func (v *VoteAPI) recordVoterHistory(ctx context.Context, vote db.Vote) error {
+	historyURL := fmt.Sprintf("%s/voters/%d/polls/%d", v.voterAPIURL, vote.VoterID, vote.PollID)
+
+	resp, err := v.apiClient.R().SetContext(detach(ctx)).Post(historyURL)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("voter API returned %s for (%s)", resp.Status(), historyURL)
+	}
+
+	return nil
+}
+
+// Helper to remove the poll a vote was cast on from the voter's history
+// through DELETE /voters/:id/polls/:pollid on the voter API.  A voter that
+// no longer exists has no history to fix, so that is not an error
+func (v *VoteAPI) removeVoterHistory(ctx context.Context, vote db.Vote) error {
+	historyURL := fmt.Sprintf("%s/voters/%d/polls/%d", v.voterAPIURL, vote.VoterID, vote.PollID)
+
+	resp, err := v.apiClient.R().SetContext(detach(ctx)).Delete(historyURL)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() && resp.StatusCode() != http.StatusNotFound {
+		return fmt.Errorf("voter API returned %s for (%s)", resp.Status(), historyURL)
+	}
+
+	return nil
+}
@@ -0,0 +1,323 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"drexel.edu/common/web"
+	"drexel.edu/votes-api/db"
+	"github.com/gin-gonic/gin"
+)
+
+// fakeDownstream stands in for the poll and voter APIs.  Voters 1 to 3
+// exist, and the voter history calls fail for voters in failHistory
+type fakeDownstream struct {
+	mu          sync.Mutex
+	polls       map[uint]db.Poll
+	failHistory map[uint]bool
+}
+
+func (f *fakeDownstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	id, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case parts[0] == "polls" && len(parts) == 2:
+		poll, ok := f.polls[uint(id)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(poll)
+	case parts[0] == "voters" && (id < 1 || id > 3):
+		http.NotFound(w, r)
+	case parts[0] == "voters" && len(parts) == 2:
+		json.NewEncoder(w).Encode(db.Voter{VoterId: uint(id)})
+	case parts[0] == "voters" && len(parts) == 4 && f.failHistory[uint(id)]:
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	case parts[0] == "voters" && len(parts) == 4:
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Helper to build the vote routes on top of the memory store.  Poll 1 is
+// open, poll 2 is a draft and poll 3 is closed with final results
+func newTestRouter(t *testing.T) (*gin.Engine, *fakeDownstream) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	options := []db.PollOption{
+		{PollOptionID: 1, PollOptionText: "Dog"},
+		{PollOptionID: 2, PollOptionText: "Cat"},
+	}
+	downstream := &fakeDownstream{
+		polls: map[uint]db.Poll{
+			1: {PollID: 1, PollTitle: "Pets", PollOptions: options, Status: db.PollStatusOpen},
+			2: {PollID: 2, PollTitle: "Draft", PollOptions: options, Status: db.PollStatusDraft},
+			3: {PollID: 3, PollTitle: "Closed", PollOptions: options, Status: db.PollStatusClosed,
+				FinalResults: &db.PollResults{PollID: 3, TotalVotes: 7}},
+		},
+		failHistory: make(map[uint]bool),
+	}
+	server := httptest.NewServer(downstream)
+	t.Cleanup(server.Close)
+
+	apiHandler, err := NewVoteAPI(db.StoreMemory, db.RedisConfig{}, "", server.URL, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		apiHandler.EndStreams()
+		apiHandler.Close()
+	})
+
+	r := gin.New()
+	r.POST("/votes", apiHandler.CreateVote)
+	r.POST("/votes/:id", apiHandler.AddVote)
+	r.GET("/votes", apiHandler.GetAllVotes)
+	r.GET("/votes/:id", apiHandler.GetVote)
+	r.DELETE("/votes/:id", apiHandler.DeleteVote)
+	r.GET("/polls/:id/results", apiHandler.GetPollResults)
+	r.GET("/polls/:id/results/stream", apiHandler.StreamPollResults)
+	r.GET("/polls/:id/votes", apiHandler.GetPollVotes)
+	r.DELETE("/polls/:id/votes", apiHandler.DeletePollVotes)
+	r.DELETE("/voters/:id/votes", apiHandler.DeleteVoterVotes)
+	return r, downstream
+}
+
+// Helper to send a request to the router and return the recorded response
+func serve(r http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// Helper to check a response's status and, for errors, its code
+func checkResponse(t *testing.T, w *httptest.ResponseRecorder, status int, code string) web.ErrorResponse {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, status, w.Body)
+	}
+	var resp web.ErrorResponse
+	if code == "" {
+		return resp
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error body %s: %v", w.Body, err)
+	}
+	if resp.Code != code {
+		t.Errorf("code = %q, want %q", resp.Code, code)
+	}
+	return resp
+}
+
+// Helper to read the results for a poll through the API
+func getResults(t *testing.T, r http.Handler, pollId uint) db.PollResults {
+	t.Helper()
+	w := serve(r, http.MethodGet, "/polls/"+strconv.FormatUint(uint64(pollId), 10)+"/results", "")
+	checkResponse(t, w, http.StatusOK, "")
+
+	var results db.PollResults
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestCastVote(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	w := serve(r, http.MethodPost, "/votes", `{"voterId": 1, "pollId": 1, "voteValue": 2}`)
+	checkResponse(t, w, http.StatusCreated, "")
+	if got := w.Header().Get("Location"); got != "/votes/1" {
+		t.Errorf("Location = %q, want /votes/1", got)
+	}
+
+	w = serve(r, http.MethodGet, "/votes/1", "")
+	checkResponse(t, w, http.StatusOK, "")
+	var vote db.Vote
+	if err := json.Unmarshal(w.Body.Bytes(), &vote); err != nil {
+		t.Fatal(err)
+	}
+	if vote.VoterID != 1 || vote.PollID != 1 || vote.VoteValue != 2 {
+		t.Errorf("GET returned %+v", vote)
+	}
+
+	//One vote per voter per poll, whichever route is used
+	checkResponse(t, serve(r, http.MethodPost, "/votes", `{"voterId": 1, "pollId": 1, "voteValue": 1}`),
+		http.StatusConflict, web.CodeConflict)
+	checkResponse(t, serve(r, http.MethodPost, "/votes/9", `{"voterId": 1, "pollId": 1, "voteValue": 1}`),
+		http.StatusConflict, web.CodeConflict)
+}
+
+func TestCastVoteRejected(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{"invalid json", "/votes", `{"voterId": `, http.StatusBadRequest, web.CodeInvalidRequest},
+		{"client chosen id", "/votes", `{"voteId": 4, "voterId": 1, "pollId": 1, "voteValue": 1}`, http.StatusBadRequest, web.CodeInvalidRequest},
+		{"invalid vote id", "/votes/abc", `{"voterId": 1, "pollId": 1, "voteValue": 1}`, http.StatusBadRequest, web.CodeInvalidRequest},
+		{"id mismatch", "/votes/4", `{"voteId": 5, "voterId": 1, "pollId": 1, "voteValue": 1}`, http.StatusBadRequest, web.CodeInvalidRequest},
+		{"unknown voter", "/votes", `{"voterId": 9, "pollId": 1, "voteValue": 1}`, http.StatusNotFound, web.CodeNotFound},
+		{"unknown poll", "/votes", `{"voterId": 1, "pollId": 9, "voteValue": 1}`, http.StatusNotFound, web.CodeNotFound},
+		{"draft poll", "/votes", `{"voterId": 1, "pollId": 2, "voteValue": 1}`, http.StatusConflict, web.CodeConflict},
+		{"closed poll", "/votes", `{"voterId": 1, "pollId": 3, "voteValue": 1}`, http.StatusConflict, web.CodeConflict},
+		{"unknown option", "/votes", `{"voterId": 1, "pollId": 1, "voteValue": 7}`, http.StatusUnprocessableEntity, web.CodeInvalidEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkResponse(t, serve(r, http.MethodPost, tt.path, tt.body), tt.status, tt.code)
+		})
+	}
+
+	if results := getResults(t, r, 1); results.TotalVotes != 0 {
+		t.Errorf("rejected votes were counted: %+v", results)
+	}
+}
+
+func TestCastVoteRollsBack(t *testing.T) {
+	r, downstream := newTestRouter(t)
+	downstream.failHistory[2] = true
+
+	checkResponse(t, serve(r, http.MethodPost, "/votes", `{"voterId": 2, "pollId": 1, "voteValue": 1}`),
+		http.StatusBadGateway, web.CodeUpstreamError)
+	if results := getResults(t, r, 1); results.TotalVotes != 0 {
+		t.Errorf("vote was kept after the voter history failed: %+v", results)
+	}
+}
+
+func TestGetPollResults(t *testing.T) {
+	r, _ := newTestRouter(t)
+	for i, value := range []int{1, 1, 2} {
+		body := `{"voterId": ` + strconv.Itoa(i+1) + `, "pollId": 1, "voteValue": ` + strconv.Itoa(value) + `}`
+		checkResponse(t, serve(r, http.MethodPost, "/votes", body), http.StatusCreated, "")
+	}
+
+	results := getResults(t, r, 1)
+	if results.TotalVotes != 3 || len(results.Results) != 2 ||
+		results.Results[0].Count != 2 || results.Results[1].Count != 1 {
+		t.Errorf("results = %+v, want 2 for Dog and 1 for Cat", results)
+	}
+
+	//A closed poll reports the results frozen when it closed
+	if results := getResults(t, r, 3); results.TotalVotes != 7 {
+		t.Errorf("closed poll results = %+v, want the final results", results)
+	}
+
+	for _, path := range []string{"/polls/abc/results", "/polls/-1/results"} {
+		resp := checkResponse(t, serve(r, http.MethodGet, path, ""), http.StatusBadRequest, web.CodeInvalidRequest)
+		if resp.Message != "Invalid poll ID" {
+			t.Errorf("%s: message = %q, want %q", path, resp.Message, "Invalid poll ID")
+		}
+	}
+	checkResponse(t, serve(r, http.MethodGet, "/polls/9/results", ""), http.StatusNotFound, web.CodeNotFound)
+}
+
+func TestDeleteVotesFor(t *testing.T) {
+	r, _ := newTestRouter(t)
+	checkResponse(t, serve(r, http.MethodPost, "/votes", `{"voterId": 1, "pollId": 1, "voteValue": 1}`), http.StatusCreated, "")
+	checkResponse(t, serve(r, http.MethodPost, "/votes", `{"voterId": 2, "pollId": 1, "voteValue": 2}`), http.StatusCreated, "")
+
+	tests := []struct {
+		path    string
+		message string
+	}{
+		{"/polls/abc/votes", "Invalid poll ID"},
+		{"/polls/-1/votes", "Invalid poll ID"},
+		{"/voters/abc/votes", "Invalid voter ID"},
+		{"/voters/-1/votes", "Invalid voter ID"},
+	}
+	for _, tt := range tests {
+		resp := checkResponse(t, serve(r, http.MethodDelete, tt.path, ""), http.StatusBadRequest, web.CodeInvalidRequest)
+		if resp.Message != tt.message {
+			t.Errorf("%s: message = %q, want %q", tt.path, resp.Message, tt.message)
+		}
+	}
+
+	w := serve(r, http.MethodDelete, "/voters/1/votes", "")
+	checkResponse(t, w, http.StatusOK, "")
+	if !strings.Contains(w.Body.String(), `"deleted":1`) {
+		t.Errorf("deleting voter 1's votes returned %s", w.Body)
+	}
+
+	w = serve(r, http.MethodDelete, "/polls/1/votes", "")
+	checkResponse(t, w, http.StatusOK, "")
+	if !strings.Contains(w.Body.String(), `"deleted":1`) {
+		t.Errorf("deleting poll 1's votes returned %s", w.Body)
+	}
+	if results := getResults(t, r, 1); results.TotalVotes != 0 {
+		t.Errorf("votes left after deleting: %+v", results)
+	}
+}
+
+func TestStreamPollResults(t *testing.T) {
+	r, _ := newTestRouter(t)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/polls/1/results/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	lines := bufio.NewScanner(resp.Body)
+	nextResults := func() db.PollResults {
+		t.Helper()
+		for lines.Scan() {
+			data, ok := strings.CutPrefix(lines.Text(), "data:")
+			if !ok {
+				continue
+			}
+			var results db.PollResults
+			if err := json.Unmarshal([]byte(data), &results); err != nil {
+				t.Fatalf("event data %s: %v", data, err)
+			}
+			return results
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return db.PollResults{}
+	}
+
+	if results := nextResults(); results.TotalVotes != 0 {
+		t.Errorf("first event = %+v, want no votes", results)
+	}
+
+	checkResponse(t, serve(r, http.MethodPost, "/votes", `{"voterId": 1, "pollId": 1, "voteValue": 2}`), http.StatusCreated, "")
+	if results := nextResults(); results.TotalVotes != 1 || results.Results[1].Count != 1 {
+		t.Errorf("event after voting = %+v, want one vote for Cat", results)
+	}
+}
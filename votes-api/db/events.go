@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"drexel.edu/common/events"
+)
+
+// EventSource names this service on the domain events it publishes
+const EventSource = "votes-api"
+
+// Helper to append an event to the domain event stream.  The change the
+// event describes has already been written, so a failure to publish is
+// logged rather than returned
+func (c *cache) publishEvent(eventType string, entityId uint, data interface{}) {
+	err := events.Publish(c.context, c.cacheClient, c.key(events.StreamKey),
+		EventSource, eventType, entityId, data)
+	if err != nil {
+		slog.ErrorContext(c.context, "Error publishing event", "type", eventType, "error", err)
+	}
+}
+
+// LatestEventID returns the id of the newest entry on the domain event
+// stream, or "0-0" if the stream is empty.  It is the starting point for
+// ReadEvents when only future events are wanted
+func (c *cache) LatestEventID() (string, error) {
+	return events.Latest(c.context, c.cacheClient, c.key(events.StreamKey))
+}
+
+// ReadEvents waits up to block for events published after lastID.  Every
+// reader sees every event.  It returns the events along with the id to
+// pass to the next call
+func (c *cache) ReadEvents(ctx context.Context, lastID string, block time.Duration) ([]events.Event, string, error) {
+	return events.Read(ctx, c.cacheClient, c.key(events.StreamKey), lastID, block)
+}
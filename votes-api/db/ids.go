@@ -0,0 +1,4 @@
+package db
+
+// Counter used to allocate vote ids on the server
+const RedisIdSeqKey = "vote-id-seq"
@@ -0,0 +1,5 @@
+package db
+
+// Sorted set holding every vote id scored by the id itself, so votes can
+// be listed in id order a page at a time
+const RedisIndexKey = "vote-index"
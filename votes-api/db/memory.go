@@ -0,0 +1,319 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"drexel.edu/common/events"
+	"drexel.edu/common/storage"
+)
+
+// MemoryVoteList keeps votes in process memory instead of redis, so the
+// API can run without a redis container.  Nothing survives a restart and
+// it can't be shared between replicas.  Events are only kept in process,
+// so results streams see the votes cast here but not changes made by the
+// other services
+type MemoryVoteList struct {
+	mu         sync.Mutex
+	votes      map[uint]Vote
+	lastVoteId uint
+	pollVoters map[uint]map[uint]uint
+	events     memoryEventLog
+}
+
+func NewMemoryVoteList() *MemoryVoteList {
+	return &MemoryVoteList{
+		votes:      make(map[uint]Vote),
+		pollVoters: make(map[uint]map[uint]uint),
+		events:     memoryEventLog{changed: make(chan struct{})},
+	}
+}
+
+// Ping always succeeds, there is nothing to reach
+func (m *MemoryVoteList) Ping() error {
+	return nil
+}
+
+// Close has nothing to release
+func (m *MemoryVoteList) Close() error {
+	return nil
+}
+
+// WithContext returns the list itself, there are no calls to trace
+func (m *MemoryVoteList) WithContext(ctx context.Context) VoteStore {
+	return m
+}
+
+// Helper to list the ids of the votes that match keep in id order, the
+// caller holds mu
+func (m *MemoryVoteList) sortedIds(keep func(Vote) bool) []uint {
+	ids := make([]uint, 0, len(m.votes))
+	for id, vote := range m.votes {
+		if keep == nil || keep(vote) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Helper to copy out the votes for a list of ids, the caller holds mu
+func (m *MemoryVoteList) getVotes(ids []uint) []Vote {
+	voteList := make([]Vote, 0, len(ids))
+	for _, id := range ids {
+		voteList = append(voteList, m.votes[id])
+	}
+	return voteList
+}
+
+// Helper with the same checks as addVoteScript, the caller holds mu
+func (m *MemoryVoteList) addVote(vote Vote) error {
+	if _, ok := m.votes[vote.VoteID]; ok {
+		return ErrVoteExists
+	}
+	if _, ok := m.pollVoters[vote.PollID][vote.VoterID]; ok {
+		return ErrAlreadyVoted
+	}
+
+	m.votes[vote.VoteID] = vote
+	if m.pollVoters[vote.PollID] == nil {
+		m.pollVoters[vote.PollID] = make(map[uint]uint)
+	}
+	m.pollVoters[vote.PollID][vote.VoterID] = vote.VoteID
+	if vote.VoteID > m.lastVoteId {
+		m.lastVoteId = vote.VoteID
+	}
+	m.events.publish(events.VoteCast, vote.VoteID, vote)
+
+	return nil
+}
+
+// Helper with the same effect as deleteVoteScript, the caller holds mu
+func (m *MemoryVoteList) deleteVote(vote Vote) {
+	delete(m.votes, vote.VoteID)
+	if m.pollVoters[vote.PollID][vote.VoterID] == vote.VoteID {
+		delete(m.pollVoters[vote.PollID], vote.VoterID)
+	}
+	m.events.publish(events.VoteDeleted, vote.VoteID, vote)
+}
+
+// Helper to delete every vote that matches keep
+func (m *MemoryVoteList) deleteVotes(keep func(Vote) bool) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := m.sortedIds(keep)
+	for _, id := range ids {
+		m.deleteVote(m.votes[id])
+	}
+	return len(ids), nil
+}
+
+// Helper to read a page of the votes that match keep
+func (m *MemoryVoteList) votesPage(keep func(Vote) bool, cursor string, limit int) ([]Vote, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids, next, err := storage.PageOfIds(m.sortedIds(keep), cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	return m.getVotes(ids), next, nil
+}
+
+// Helper to read every vote that matches keep
+func (m *MemoryVoteList) votesWhere(keep func(Vote) bool) []Vote {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.getVotes(m.sortedIds(keep))
+}
+
+func onPoll(pollId uint) func(Vote) bool {
+	return func(vote Vote) bool { return vote.PollID == pollId }
+}
+
+func byVoter(voterId uint) func(Vote) bool {
+	return func(vote Vote) bool { return vote.VoterID == voterId }
+}
+
+func (m *MemoryVoteList) AddVote(vote Vote) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.addVote(vote)
+}
+
+func (m *MemoryVoteList) CreateVote(vote *Vote) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//Ids chosen by clients raise lastVoteId too, so the next id is free
+	vote.VoteID = m.lastVoteId + 1
+	return m.addVote(*vote)
+}
+
+func (m *MemoryVoteList) DeleteVote(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	vote, ok := m.votes[id]
+	if !ok {
+		return ErrVoteNotFound
+	}
+	m.deleteVote(vote)
+
+	return nil
+}
+
+func (m *MemoryVoteList) DeleteAll() error {
+	_, err := m.deleteVotes(nil)
+	return err
+}
+
+func (m *MemoryVoteList) GetSingleVoterResource(id uint) (Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	vote, ok := m.votes[id]
+	if !ok {
+		return Vote{}, ErrVoteNotFound
+	}
+	return vote, nil
+}
+
+func (m *MemoryVoteList) GetAllVotes() ([]Vote, error) {
+	return m.votesWhere(nil), nil
+}
+
+func (m *MemoryVoteList) GetVotesPage(cursor string, limit int) ([]Vote, string, error) {
+	return m.votesPage(nil, cursor, limit)
+}
+
+func (m *MemoryVoteList) GetVotesForPoll(pollId uint) ([]Vote, error) {
+	return m.votesWhere(onPoll(pollId)), nil
+}
+
+func (m *MemoryVoteList) GetVotesForPollPage(pollId uint, cursor string, limit int) ([]Vote, string, error) {
+	return m.votesPage(onPoll(pollId), cursor, limit)
+}
+
+func (m *MemoryVoteList) DeleteVotesForPoll(pollId uint) (int, error) {
+	return m.deleteVotes(onPoll(pollId))
+}
+
+func (m *MemoryVoteList) GetVotesForVoter(voterId uint) ([]Vote, error) {
+	return m.votesWhere(byVoter(voterId)), nil
+}
+
+func (m *MemoryVoteList) GetVotesForVoterPage(voterId uint, cursor string, limit int) ([]Vote, string, error) {
+	return m.votesPage(byVoter(voterId), cursor, limit)
+}
+
+func (m *MemoryVoteList) DeleteVotesForVoter(voterId uint) (int, error) {
+	return m.deleteVotes(byVoter(voterId))
+}
+
+func (m *MemoryVoteList) LatestEventID() (string, error) {
+	return m.events.latestID(), nil
+}
+
+func (m *MemoryVoteList) ReadEvents(ctx context.Context, lastID string, block time.Duration) ([]events.Event, string, error) {
+	return m.events.read(ctx, lastID, block)
+}
+
+// memoryEventLog stands in for the redis event stream.  Events get ids
+// shaped like stream ids so callers can treat both the same way
+type memoryEventLog struct {
+	mu     sync.Mutex
+	events []events.Event
+	//closed and replaced whenever an event is published, so readers can
+	//wait for the next one
+	changed chan struct{}
+}
+
+// Helper to add an event to the log, trimmed like the redis stream
+func (l *memoryEventLog) publish(eventType string, entityId uint, data interface{}) {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		slog.Error("Error encoding event", "type", eventType, "error", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var seq uint64 = 1
+	if len(l.events) > 0 {
+		seq = eventSeq(l.events[len(l.events)-1].ID) + 1
+	}
+	l.events = append(l.events, events.Event{
+		ID:         fmt.Sprintf("%d-0", seq),
+		Type:       eventType,
+		EntityID:   entityId,
+		Source:     EventSource,
+		OccurredAt: time.Now().UTC(),
+		Data:       dataJSON,
+	})
+	if len(l.events) > events.MaxLen {
+		l.events = l.events[len(l.events)-events.MaxLen:]
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *memoryEventLog) latestID() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.events) == 0 {
+		return "0-0"
+	}
+	return l.events[len(l.events)-1].ID
+}
+
+// Helper that works like ReadEvents on the redis stream
+func (l *memoryEventLog) read(ctx context.Context, lastID string, block time.Duration) ([]events.Event, string, error) {
+	after := eventSeq(lastID)
+	timer := time.NewTimer(block)
+	defer timer.Stop()
+
+	for {
+		l.mu.Lock()
+		var read []events.Event
+		for _, event := range l.events {
+			if eventSeq(event.ID) > after && len(read) < events.ReadCount {
+				read = append(read, event)
+			}
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		if len(read) > 0 {
+			return read, read[len(read)-1].ID, nil
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, lastID, nil
+		case <-ctx.Done():
+			return nil, lastID, ctx.Err()
+		}
+	}
+}
+
+// Helper to read the sequence number out of an id like 12-0
+func eventSeq(id string) uint64 {
+	seqS, _, _ := strings.Cut(id, "-")
+	seq, _ := strconv.ParseUint(seqS, 10, 64)
+	return seq
+}
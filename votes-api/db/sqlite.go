@@ -0,0 +1,421 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"drexel.edu/common/events"
+	"drexel.edu/common/storage"
+	_ "modernc.org/sqlite"
+)
+
+// DefaultSQLitePath is where the sqlite store keeps its database unless
+// told otherwise
+const DefaultSQLitePath = "votes.db"
+
+// How often ReadEvents checks the events table for new rows
+const sqliteEventPollInterval = 250 * time.Millisecond
+
+// Polls and voters live in the other services' databases, so votes can't
+// have foreign keys to them.  The unique constraint is what stops a voter
+// voting twice in a poll.  events stands in for the redis event stream
+// and only holds this service's events, with times stored as RFC 3339 text
+const sqliteVoteSchema = `
+CREATE TABLE IF NOT EXISTS votes (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	poll_id    INTEGER NOT NULL,
+	voter_id   INTEGER NOT NULL,
+	vote_value INTEGER NOT NULL,
+	UNIQUE (poll_id, voter_id)
+);
+
+CREATE INDEX IF NOT EXISTS votes_poll_id ON votes (poll_id, id);
+CREATE INDEX IF NOT EXISTS votes_voter_id ON votes (voter_id, id);
+
+CREATE TABLE IF NOT EXISTS events (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	type        TEXT NOT NULL,
+	entity_id   INTEGER NOT NULL,
+	source      TEXT NOT NULL,
+	occurred_at TEXT NOT NULL,
+	data        TEXT
+);
+`
+
+// SQLiteVoteList keeps votes in a sqlite database file, so they survive
+// restarts without redis.  Replicas on the same host can share the file,
+// and with it the events that feed the results streams, but nothing is
+// published to the other services
+type SQLiteVoteList struct {
+	db *sql.DB
+}
+
+// NewSQLiteVoteList opens, or creates, the database at path
+func NewSQLiteVoteList(path string) (*SQLiteVoteList, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(sqliteVoteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteVoteList{db: db}, nil
+}
+
+// Ping checks that the database file can still be used
+func (s *SQLiteVoteList) Ping() error {
+	return s.db.Ping()
+}
+
+// Close closes the database file
+func (s *SQLiteVoteList) Close() error {
+	return s.db.Close()
+}
+
+// WithContext returns the list itself, only redis commands are traced
+func (s *SQLiteVoteList) WithContext(ctx context.Context) VoteStore {
+	return s
+}
+
+// Helper to open a sqlite database with foreign keys turned on.  Write
+// transactions take the write lock up front and wait for other writers,
+// instead of failing when two of them try to upgrade at once
+func openSQLite(path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_txlock=immediate" +
+		"&_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Helper to run fn in a transaction, committing if it succeeds
+func (s *SQLiteVoteList) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// querier is what the read helpers need, so they work both inside and
+// outside a transaction
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Helper to read the votes a query selects
+func loadVotes(q querier, query string, args ...interface{}) ([]Vote, error) {
+	rows, err := q.Query(`SELECT id, voter_id, poll_id, vote_value FROM votes `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	voteList := []Vote{}
+	for rows.Next() {
+		var vote Vote
+		if err := rows.Scan(&vote.VoteID, &vote.VoterID, &vote.PollID, &vote.VoteValue); err != nil {
+			return nil, err
+		}
+		voteList = append(voteList, vote)
+	}
+
+	return voteList, rows.Err()
+}
+
+// Helper to read a page of the votes matching where, which must leave
+// room for the id condition to be added
+func (s *SQLiteVoteList) votesPage(where string, arg interface{}, cursor string, limit int) ([]Vote, string, error) {
+	limit = storage.PageLimit(limit)
+
+	var after uint
+	if cursor != "" {
+		var err error
+		if after, err = storage.ParseCursor(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	//Read one vote past the page to find out whether there is another
+	query := "WHERE id > ? ORDER BY id LIMIT ?"
+	args := []interface{}{after, limit + 1}
+	if where != "" {
+		query = "WHERE " + where + " AND id > ? ORDER BY id LIMIT ?"
+		args = append([]interface{}{arg}, args...)
+	}
+	voteList, err := loadVotes(s.db, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(voteList) <= limit {
+		return voteList, "", nil
+	}
+
+	ids := make([]uint, len(voteList))
+	for i, vote := range voteList {
+		ids[i] = vote.VoteID
+	}
+	_, next, err := storage.TrimPage(ids, limit)
+	return voteList[:limit], next, err
+}
+
+// Helper to add an event to the events table, trimmed like the redis
+// stream
+func publishEventTx(tx *sql.Tx, eventType string, entityId uint, data interface{}) error {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`INSERT INTO events (type, entity_id, source, occurred_at, data)
+		VALUES (?, ?, ?, ?, ?)`,
+		eventType, entityId, EventSource, time.Now().UTC().Format(time.RFC3339Nano), string(dataJSON))
+	if err != nil {
+		return err
+	}
+
+	seq, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM events WHERE id <= ?`, seq-events.MaxLen)
+	return err
+}
+
+// Helper to insert a vote after the same checks as addVoteScript.  With
+// allocate set the vote takes the next free id, otherwise it keeps its own
+func addVoteTx(tx *sql.Tx, vote *Vote, allocate bool) error {
+	var n int
+	if !allocate {
+		err := tx.QueryRow(`SELECT COUNT(*) FROM votes WHERE id = ?`, vote.VoteID).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrVoteExists
+		}
+	}
+
+	err := tx.QueryRow(`SELECT COUNT(*) FROM votes WHERE poll_id = ? AND voter_id = ?`,
+		vote.PollID, vote.VoterID).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrAlreadyVoted
+	}
+
+	var id interface{}
+	if !allocate {
+		id = vote.VoteID
+	}
+	res, err := tx.Exec(`INSERT INTO votes (id, poll_id, voter_id, vote_value) VALUES (?, ?, ?, ?)`,
+		id, vote.PollID, vote.VoterID, vote.VoteValue)
+	if err != nil {
+		return err
+	}
+	if allocate {
+		newId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		vote.VoteID = uint(newId)
+	}
+
+	return publishEventTx(tx, events.VoteCast, vote.VoteID, *vote)
+}
+
+// Helper to delete every vote matching where, publishing an event for each
+func (s *SQLiteVoteList) deleteVotes(where string, args ...interface{}) (int, error) {
+	var numDeleted int
+	err := s.inTx(func(tx *sql.Tx) error {
+		voteList, err := loadVotes(tx, where, args...)
+		if err != nil {
+			return err
+		}
+
+		for _, vote := range voteList {
+			if _, err := tx.Exec(`DELETE FROM votes WHERE id = ?`, vote.VoteID); err != nil {
+				return err
+			}
+			if err := publishEventTx(tx, events.VoteDeleted, vote.VoteID, vote); err != nil {
+				return err
+			}
+		}
+
+		numDeleted = len(voteList)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return numDeleted, nil
+}
+
+func (s *SQLiteVoteList) AddVote(vote Vote) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		return addVoteTx(tx, &vote, false)
+	})
+}
+
+// CreateVote adds a vote under the next free id and writes that id back
+// into the vote.  sqlite remembers the highest id it has seen, including
+// ids chosen by clients, so the id is always free
+func (s *SQLiteVoteList) CreateVote(vote *Vote) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		return addVoteTx(tx, vote, true)
+	})
+}
+
+func (s *SQLiteVoteList) DeleteVote(id uint) error {
+	numDeleted, err := s.deleteVotes("WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if numDeleted == 0 {
+		return ErrVoteNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteVoteList) DeleteAll() error {
+	_, err := s.deleteVotes("")
+	return err
+}
+
+func (s *SQLiteVoteList) GetSingleVoterResource(id uint) (Vote, error) {
+	voteList, err := loadVotes(s.db, "WHERE id = ?", id)
+	if err != nil {
+		return Vote{}, err
+	}
+	if len(voteList) == 0 {
+		return Vote{}, ErrVoteNotFound
+	}
+	return voteList[0], nil
+}
+
+func (s *SQLiteVoteList) GetAllVotes() ([]Vote, error) {
+	return loadVotes(s.db, "ORDER BY id")
+}
+
+func (s *SQLiteVoteList) GetVotesPage(cursor string, limit int) ([]Vote, string, error) {
+	return s.votesPage("", nil, cursor, limit)
+}
+
+func (s *SQLiteVoteList) GetVotesForPoll(pollId uint) ([]Vote, error) {
+	return loadVotes(s.db, "WHERE poll_id = ? ORDER BY id", pollId)
+}
+
+func (s *SQLiteVoteList) GetVotesForPollPage(pollId uint, cursor string, limit int) ([]Vote, string, error) {
+	return s.votesPage("poll_id = ?", pollId, cursor, limit)
+}
+
+func (s *SQLiteVoteList) DeleteVotesForPoll(pollId uint) (int, error) {
+	return s.deleteVotes("WHERE poll_id = ?", pollId)
+}
+
+func (s *SQLiteVoteList) GetVotesForVoter(voterId uint) ([]Vote, error) {
+	return loadVotes(s.db, "WHERE voter_id = ? ORDER BY id", voterId)
+}
+
+func (s *SQLiteVoteList) GetVotesForVoterPage(voterId uint, cursor string, limit int) ([]Vote, string, error) {
+	return s.votesPage("voter_id = ?", voterId, cursor, limit)
+}
+
+func (s *SQLiteVoteList) DeleteVotesForVoter(voterId uint) (int, error) {
+	return s.deleteVotes("WHERE voter_id = ?", voterId)
+}
+
+func (s *SQLiteVoteList) LatestEventID() (string, error) {
+	var seq sql.NullInt64
+	if err := s.db.QueryRow(`SELECT MAX(id) FROM events`).Scan(&seq); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-0", seq.Int64), nil
+}
+
+// ReadEvents works like ReadEvents on the redis stream, except that it
+// checks the events table for new rows every sqliteEventPollInterval
+// instead of blocking on the server
+func (s *SQLiteVoteList) ReadEvents(ctx context.Context, lastID string, block time.Duration) ([]events.Event, string, error) {
+	after := eventSeq(lastID)
+	deadline := time.Now().Add(block)
+
+	for {
+		read, seq, err := s.eventsAfter(after)
+		if err != nil {
+			return nil, lastID, err
+		}
+		if seq > after {
+			return read, fmt.Sprintf("%d-0", seq), nil
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, lastID, nil
+		}
+		if wait > sqliteEventPollInterval {
+			wait = sqliteEventPollInterval
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, lastID, ctx.Err()
+		}
+	}
+}
+
+// Helper to read the events after the one with sequence number after.
+// It also returns the sequence number of the last row read, which is
+// after itself if there were none
+func (s *SQLiteVoteList) eventsAfter(after uint64) ([]events.Event, uint64, error) {
+	rows, err := s.db.Query(`SELECT id, type, entity_id, source, occurred_at, data FROM events
+		WHERE id > ? ORDER BY id LIMIT ?`, after, events.ReadCount)
+	if err != nil {
+		return nil, after, err
+	}
+	defer rows.Close()
+
+	var read []events.Event
+	seq := after
+	for rows.Next() {
+		var event events.Event
+		var occurredAt string
+		var data sql.NullString
+		if err := rows.Scan(&seq, &event.Type, &event.EntityID, &event.Source, &occurredAt, &data); err != nil {
+			return nil, after, err
+		}
+
+		event.ID = fmt.Sprintf("%d-0", seq)
+		if event.OccurredAt, err = time.Parse(time.RFC3339Nano, occurredAt); err != nil {
+			slog.Warn("Skipping malformed event", "id", event.ID, "error", err)
+			continue
+		}
+		if data.Valid {
+			event.Data = json.RawMessage(data.String)
+		}
+		read = append(read, event)
+	}
+
+	return read, seq, rows.Err()
+}
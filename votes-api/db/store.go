@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"drexel.edu/common/config"
+	"drexel.edu/common/events"
+)
+
+// The stores NewStore knows how to build
+const (
+	StoreRedis  = config.StoreRedis
+	StoreMemory = config.StoreMemory
+	StoreSQLite = config.StoreSQLite
+)
+
+// VoteStore is everything the API needs from the place votes are kept.
+// VoteList keeps them in redis, MemoryVoteList keeps them in process
+// memory and SQLiteVoteList keeps them in a sqlite file
+type VoteStore interface {
+	AddVote(vote Vote) error
+	CreateVote(vote *Vote) error
+	DeleteVote(id uint) error
+	DeleteAll() error
+	GetSingleVoterResource(id uint) (Vote, error)
+	GetAllVotes() ([]Vote, error)
+	GetVotesPage(cursor string, limit int) ([]Vote, string, error)
+
+	GetVotesForPoll(pollId uint) ([]Vote, error)
+	GetVotesForPollPage(pollId uint, cursor string, limit int) ([]Vote, string, error)
+	DeleteVotesForPoll(pollId uint) (int, error)
+	GetVotesForVoter(voterId uint) ([]Vote, error)
+	GetVotesForVoterPage(voterId uint, cursor string, limit int) ([]Vote, string, error)
+	DeleteVotesForVoter(voterId uint) (int, error)
+
+	LatestEventID() (string, error)
+	ReadEvents(ctx context.Context, lastID string, block time.Duration) ([]events.Event, string, error)
+
+	Ping() error
+	Close() error
+	WithContext(ctx context.Context) VoteStore
+}
+
+var (
+	_ VoteStore = (*VoteList)(nil)
+	_ VoteStore = (*MemoryVoteList)(nil)
+	_ VoteStore = (*SQLiteVoteList)(nil)
+)
+
+// NewStore builds the store named by kind, one of StoreRedis, StoreMemory
+// or StoreSQLite.  redisCfg is only used by StoreRedis, sqlitePath is the
+// database file and is only used by StoreSQLite
+func NewStore(kind string, redisCfg RedisConfig, sqlitePath string) (VoteStore, error) {
+	switch kind {
+	case StoreRedis:
+		return NewWithConfig(redisCfg)
+	case StoreMemory:
+		return NewMemoryVoteList(), nil
+	case StoreSQLite:
+		return NewSQLiteVoteList(sqlitePath)
+	}
+	return nil, fmt.Errorf("unknown store %q, use %s, %s or %s", kind, StoreRedis, StoreMemory, StoreSQLite)
+}
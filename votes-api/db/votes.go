@@ -10,36 +10,140 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"math"
 	"time"
 
+	"drexel.edu/common/events"
+	"drexel.edu/common/storage"
 	"github.com/go-redis/redis/v8"
 	"github.com/nitishm/go-rejson/v4"
 )
 
 const (
-	RedisNilError        = "redis: nil"
-	RedisDefaultLocation = "0.0.0.0:6379"
-	RedisKeyPrefix       = "vote:"
+	RedisNilError         = "redis: nil"
+	RedisDefaultLocation  = "0.0.0.0:6379"
+	RedisKeyPrefix        = "vote:"
+	RedisPollVotersPrefix = "poll-voters:"
+	RedisPollVotesPrefix  = "poll-votes:"
+	RedisVoterVotesPrefix = "voter-votes:"
 )
 
+var (
+	ErrVoteExists   = storage.NewError(storage.ErrConflict, "vote already exists")
+	ErrAlreadyVoted = storage.NewError(storage.ErrConflict, "voter has already voted in this poll")
+	ErrVoteNotFound = storage.NewError(storage.ErrNotFound, "vote does not exist")
+)
+
+// addVoteScript writes a vote and records the voter in the poll's voter
+// hash in one atomic step, so two concurrent votes from the same voter on
+// the same poll can never both be accepted.
+//
+// KEYS[1] is the vote key, KEYS[2] is the poll-voters hash, KEYS[3] is
+// the vote index, KEYS[4] and KEYS[5] are the poll-votes and voter-votes
+// sets
+// ARGV[1] is the voter id, ARGV[2] is the vote id, ARGV[3] is the vote JSON
+var addVoteScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 1
+end
+if redis.call('HEXISTS', KEYS[2], ARGV[1]) == 1 then
+	return 2
+end
+redis.call('JSON.SET', KEYS[1], '.', ARGV[3])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[2])
+redis.call('ZADD', KEYS[4], ARGV[2], ARGV[2])
+redis.call('ZADD', KEYS[5], ARGV[2], ARGV[2])
+return 0
+`)
+
+// deleteVoteScript removes a vote and, if the poll-voters hash still points
+// at it, the voter's entry for that poll.
+//
+// KEYS[1] is the vote key, KEYS[2] is the poll-voters hash, KEYS[3] is
+// the vote index, KEYS[4] and KEYS[5] are the poll-votes and voter-votes
+// sets
+// ARGV[1] is the voter id, ARGV[2] is the vote id
+var deleteVoteScript = redis.NewScript(`
+local deleted = redis.call('DEL', KEYS[1])
+if redis.call('HGET', KEYS[2], ARGV[1]) == ARGV[2] then
+	redis.call('HDEL', KEYS[2], ARGV[1])
+end
+redis.call('ZREM', KEYS[3], ARGV[2])
+redis.call('ZREM', KEYS[4], ARGV[2])
+redis.call('ZREM', KEYS[5], ARGV[2])
+return deleted
+`)
+
 type cache struct {
 	cacheClient *redis.Client
 	jsonHelper  *rejson.Handler
 	context     context.Context
+	keyPrefix   string
 }
 
-type pollOption struct {
+type PollOption struct {
 	PollOptionID   uint   `json:"pollOptionId"`
 	PollOptionText string `json:"pollOptionText"`
+	Retired        bool   `json:"retired,omitempty"`
 }
 
+// The poll statuses used by the poll API
+const (
+	PollStatusDraft  = "draft"
+	PollStatusOpen   = "open"
+	PollStatusClosed = "closed"
+)
+
 type Poll struct {
 	PollID       uint         `json:"pollId"`
 	PollTitle    string       `json:"pollTitle"`
 	PollQuestion string       `json:"pollQuestion"`
-	PollOptions  []pollOption `json:"pollOptions"`
+	PollOptions  []PollOption `json:"pollOptions"`
+	Status       string       `json:"status"`
+	OpensAt      *time.Time   `json:"opensAt,omitempty"`
+	ClosesAt     *time.Time   `json:"closesAt,omitempty"`
+	FinalResults *PollResults `json:"finalResults,omitempty"`
+}
+
+// AcceptingVotes reports whether the poll is open and now falls inside
+// its voting window
+func (p Poll) AcceptingVotes(now time.Time) bool {
+	if p.Status != PollStatusOpen {
+		return false
+	}
+	if p.OpensAt != nil && now.Before(*p.OpensAt) {
+		return false
+	}
+	if p.ClosesAt != nil && !now.Before(*p.ClosesAt) {
+		return false
+	}
+	return true
+}
+
+// PollIDFromEvent returns the poll a VoteCast or VoteDeleted event is about
+func PollIDFromEvent(event events.Event) (uint, bool) {
+	if event.Type != events.VoteCast && event.Type != events.VoteDeleted {
+		return 0, false
+	}
+
+	var vote Vote
+	if err := json.Unmarshal(event.Data, &vote); err != nil {
+		return 0, false
+	}
+	return vote.PollID, true
+}
+
+// HasOption reports whether optionId is one of the poll's options and can
+// still be voted for
+func (p Poll) HasOption(optionId uint) bool {
+	for _, option := range p.PollOptions {
+		if option.PollOptionID == optionId {
+			return !option.Retired
+		}
+	}
+	return false
 }
 
 type voterPoll struct {
@@ -61,6 +165,27 @@ type Vote struct {
 	VoteValue uint `json:"voteValue"`
 }
 
+// OptionResult is the tally for a single poll option
+type OptionResult struct {
+	PollOptionID   uint    `json:"pollOptionId"`
+	PollOptionText string  `json:"pollOptionText"`
+	Retired        bool    `json:"retired,omitempty"`
+	Count          uint    `json:"count"`
+	Percentage     float64 `json:"percentage"`
+}
+
+// PollResults is the aggregated view of every vote cast on a poll.  Votes
+// whose VoteValue does not match any option are reported as invalid and
+// are not included in the percentages
+type PollResults struct {
+	PollID       uint           `json:"pollId"`
+	PollTitle    string         `json:"pollTitle"`
+	PollQuestion string         `json:"pollQuestion"`
+	TotalVotes   uint           `json:"totalVotes"`
+	InvalidVotes uint           `json:"invalidVotes"`
+	Results      []OptionResult `json:"results"`
+}
+
 type VoteList struct {
 	//more things would be included in a real implementation
 
@@ -82,30 +207,66 @@ func NewSampleVote(voteId uint, pollId uint, voterId uint, voteValue uint) *Vote
 	}
 }
 
-func NewVoteList() (*VoteList, error) {
+// TallyVotes counts the votes for each option of the poll.  The results
+// keep the same order as the poll options
+func TallyVotes(poll Poll, votes []Vote) PollResults {
+	results := PollResults{
+		PollID:       poll.PollID,
+		PollTitle:    poll.PollTitle,
+		PollQuestion: poll.PollQuestion,
+		Results:      make([]OptionResult, 0, len(poll.PollOptions)),
+	}
+
+	//Map each option id to its position in the results slice so
+	//every vote can be counted with a single lookup
+	optionIndex := make(map[uint]int, len(poll.PollOptions))
+	for i, option := range poll.PollOptions {
+		optionIndex[option.PollOptionID] = i
+		results.Results = append(results.Results, OptionResult{
+			PollOptionID:   option.PollOptionID,
+			PollOptionText: option.PollOptionText,
+			Retired:        option.Retired,
+		})
+	}
+
+	for _, vote := range votes {
+		if vote.PollID != poll.PollID {
+			continue
+		}
+		i, ok := optionIndex[vote.VoteValue]
+		if !ok {
+			results.InvalidVotes++
+			continue
+		}
+		results.Results[i].Count++
+		results.TotalVotes++
+	}
 
-	//We will use an override if the REDIS_URL is provided as an environment
-	//variable, which is the preferred way to wire up a docker container
-	redisUrl := os.Getenv("CACHE_URL")
-	//This handles the default condition
-	if redisUrl == "" {
-		redisUrl = RedisDefaultLocation
+	if results.TotalVotes > 0 {
+		for i := range results.Results {
+			pct := float64(results.Results[i].Count) * 100 / float64(results.TotalVotes)
+			results.Results[i].Percentage = math.Round(pct*100) / 100
+		}
 	}
-	log.Println("using REDIS at: ", redisUrl)
-	return NewWithCacheInstance(redisUrl)
 
+	return results
 }
 
 // NewWithCacheInstance is a constructor function that returns a pointer to a new
 // ToDo struct.  It accepts a string that represents the location of the redis
 // cache.
 func NewWithCacheInstance(location string) (*VoteList, error) {
+	return NewWithConfig(RedisConfig{Addr: location})
+}
 
-	//Connect to redis.  Other options can be provided, but the
-	//defaults are OK
-	client := redis.NewClient(&redis.Options{
-		Addr: location,
-	})
+// NewWithConfig connects to the redis described by cfg and returns a list
+// that keeps votes there
+func NewWithConfig(cfg RedisConfig) (*VoteList, error) {
+
+	//Connect to redis
+	client := redis.NewClient(redisOptions(cfg))
+	client.AddHook(metricsHook{})
+	client.AddHook(tracingHook{})
 
 	//We use this context to coordinate betwen our go code and
 	//the redis operaitons
@@ -115,7 +276,7 @@ func NewWithCacheInstance(location string) (*VoteList, error) {
 	//is working
 	err := client.Ping(ctx).Err()
 	if err != nil {
-		log.Println("Error connecting to redis" + err.Error())
+		slog.Error("Error connecting to redis", "error", err)
 		return nil, err
 	}
 
@@ -128,14 +289,67 @@ func NewWithCacheInstance(location string) (*VoteList, error) {
 	jsonHelper := rejson.NewReJSONHandler()
 	jsonHelper.SetGoRedisClientWithContext(ctx, client)
 
+	voteList := &VoteList{
+		cache: cache{
+			cacheClient: client,
+			jsonHelper:  jsonHelper,
+			context:     ctx,
+			keyPrefix:   cfg.KeyPrefix,
+		},
+	}
+
+	//Votes stored before the index existed still need to be listed, and
+	//rebuilding the poll-voters hashes below reads the votes through it
+	if err := storage.RebuildIndex(voteList.context, voteList.cacheClient, voteList.key(RedisIndexKey), voteList.key(RedisKeyPrefix)); err != nil {
+		slog.Error("Error rebuilding vote index", "error", err)
+		return nil, err
+	}
+
+	//Votes written before the poll-voters hashes existed still need to
+	//count towards the one vote per poll rule, and votes written before
+	//the poll-votes and voter-votes sets existed still need to be found
+	if err := voteList.rebuildVoteIndexes(); err != nil {
+		slog.Error("Error rebuilding vote indexes", "error", err)
+		return nil, err
+	}
+
+	//Server allocated ids have to start past the votes already stored
+	if err := storage.RebuildIdSeq(voteList.context, voteList.cacheClient, voteList.key(RedisIdSeqKey), voteList.key(RedisKeyPrefix)); err != nil {
+		slog.Error("Error rebuilding vote id sequence", "error", err)
+		return nil, err
+	}
+
 	//Return a pointer to a new ToDo struct
+	return voteList, nil
+}
+
+// Ping checks that redis can still be reached
+func (lst *VoteList) Ping() error {
+	return lst.cacheClient.Ping(lst.context).Err()
+}
+
+// Close closes the redis client.  Copies made by WithContext share the
+// client, so it only needs closing once
+func (lst *VoteList) Close() error {
+	return lst.cacheClient.Close()
+}
+
+// WithContext returns a copy of the list that sends its commands with the
+// trace and request id in ctx, so they show up in the request's trace and
+// logs.  ctx's deadline and cancellation are not used
+func (lst *VoteList) WithContext(ctx context.Context) VoteStore {
+	ctx = detach(ctx)
+	jsonHelper := rejson.NewReJSONHandler()
+	jsonHelper.SetGoRedisClientWithContext(ctx, lst.cacheClient)
+
 	return &VoteList{
 		cache: cache{
-			cacheClient: client,
+			cacheClient: lst.cacheClient,
 			jsonHelper:  jsonHelper,
 			context:     ctx,
+			keyPrefix:   lst.keyPrefix,
 		},
-	}, nil
+	}
 }
 
 //------------------------------------------------------------
@@ -150,8 +364,45 @@ func isRedisNilError(err error) bool {
 // In redis, our keys will be strings, they will look like
 // todo:<number>.  This function will take an integer and
 // return a string that can be used as a key in redis
-func redisKeyFromId(id int) string {
-	return fmt.Sprintf("%s%d", RedisKeyPrefix, id)
+func (c *cache) redisKeyFromId(id int) string {
+	return fmt.Sprintf("%s%d", c.key(RedisKeyPrefix), id)
+}
+
+// The poll-voters hash for a poll maps each voter id to the id of the
+// vote they cast on that poll
+func (c *cache) pollVotersKeyFromId(pollId uint) string {
+	return fmt.Sprintf("%s%d", c.key(RedisPollVotersPrefix), pollId)
+}
+
+// The poll-votes set for a poll holds the ids of every vote cast on it
+func (c *cache) pollVotesKeyFromId(pollId uint) string {
+	return fmt.Sprintf("%s%d", c.key(RedisPollVotesPrefix), pollId)
+}
+
+// The voter-votes set for a voter holds the ids of every vote they cast
+func (c *cache) voterVotesKeyFromId(voterId uint) string {
+	return fmt.Sprintf("%s%d", c.key(RedisVoterVotesPrefix), voterId)
+}
+
+// Helper to make sure every stored vote is recorded in its poll-voters
+// hash and in its poll-votes and voter-votes sets
+func (v *VoteList) rebuildVoteIndexes() error {
+	voteList, err := v.GetAllVotes()
+	if err != nil {
+		return err
+	}
+
+	_, err = v.cacheClient.Pipelined(v.context, func(pipe redis.Pipeliner) error {
+		for _, vote := range voteList {
+			member := &redis.Z{Score: float64(vote.VoteID), Member: vote.VoteID}
+			pipe.HSetNX(v.context, v.pollVotersKeyFromId(vote.PollID), fmt.Sprint(vote.VoterID), vote.VoteID)
+			pipe.ZAdd(v.context, v.pollVotesKeyFromId(vote.PollID), member)
+			pipe.ZAdd(v.context, v.voterVotesKeyFromId(vote.VoterID), member)
+		}
+		return nil
+	})
+
+	return err
 }
 
 // Helper to return a ToDoItem from redis provided a key
@@ -162,7 +413,7 @@ func (v *VoteList) getItemFromRedis(key string, item *Vote) error {
 	//json structure
 	itemObject, err := v.jsonHelper.JSONGet(key, ".")
 	if err != nil {
-		return err
+		return storage.FromRedis(err, ErrVoteNotFound)
 	}
 
 	//JSONGet returns an "any" object, or empty interface,
@@ -171,7 +422,7 @@ func (v *VoteList) getItemFromRedis(key string, item *Vote) error {
 	//it into our ToDoItem struct
 	err = json.Unmarshal(itemObject.([]byte), item)
 	if err != nil {
-		return nil
+		return storage.CorruptRecord(key, err)
 	}
 
 	return nil
@@ -183,52 +434,123 @@ func (v *VoteList) getItemFromRedis(key string, item *Vote) error {
 
 func (lst *VoteList) AddVote(vote Vote) error {
 
-	// lst.Voters[voter.VoterId] = voter
-	// return nil
-
-	//Before we add an item to the DB, lets make sure
-	//it does not exist, if it does, return an error
+	voteJSON, err := json.Marshal(vote)
+	if err != nil {
+		return err
+	}
 
-	redisKey := redisKeyFromId(int(vote.VoteID))
-	var existingVote Vote
-	if err := lst.getItemFromRedis(redisKey, &existingVote); err == nil {
-		return errors.New("voter already exists")
+	//The script checks that neither the vote nor a vote from the same
+	//voter on this poll exists, and only then writes the vote
+	keys := []string{lst.redisKeyFromId(int(vote.VoteID)), lst.pollVotersKeyFromId(vote.PollID), lst.key(RedisIndexKey),
+		lst.pollVotesKeyFromId(vote.PollID), lst.voterVotesKeyFromId(vote.VoterID)}
+	result, err := addVoteScript.Run(lst.context, lst.cacheClient, keys,
+		vote.VoterID, vote.VoteID, string(voteJSON)).Int()
+	if err != nil {
+		return err
 	}
 
-	//Add item to database with JSON Set
-	if _, err := lst.jsonHelper.JSONSet(redisKey, ".", vote); err != nil {
+	switch result {
+	case 1:
+		return ErrVoteExists
+	case 2:
+		return ErrAlreadyVoted
+	}
+	if err := storage.RaiseIdSeq(lst.context, lst.cacheClient, lst.key(RedisIdSeqKey), vote.VoteID); err != nil {
 		return err
 	}
+	lst.publishEvent(events.VoteCast, vote.VoteID, vote)
 
 	//If everything is ok, return nil for the error
 	return nil
 }
 
+// CreateVote adds a vote under the next server allocated id and writes
+// that id back into the vote
+func (lst *VoteList) CreateVote(vote *Vote) error {
+	for attempt := 0; attempt < storage.MaxIdAttempts; attempt++ {
+		id, err := storage.NextId(lst.context, lst.cacheClient, lst.key(RedisIdSeqKey))
+		if err != nil {
+			return err
+		}
+		vote.VoteID = id
+
+		//A client may have claimed this id through POST /votes/:id
+		//between the increment and the write, if so just take the next one
+		if err := lst.AddVote(*vote); !errors.Is(err, ErrVoteExists) {
+			return err
+		}
+	}
+	return errors.New("could not allocate a vote id")
+}
+
 func (lst *VoteList) DeleteVote(id uint) error {
 
-	pattern := redisKeyFromId(int(id))
-	numDeleted, err := lst.cacheClient.Del(lst.context, pattern).Result()
+	//We need the vote itself to know which poll-voters entry to clear
+	redisKey := lst.redisKeyFromId(int(id))
+	var vote Vote
+	if err := lst.getItemFromRedis(redisKey, &vote); err != nil {
+		return err
+	}
+
+	keys := []string{redisKey, lst.pollVotersKeyFromId(vote.PollID), lst.key(RedisIndexKey),
+		lst.pollVotesKeyFromId(vote.PollID), lst.voterVotesKeyFromId(vote.VoterID)}
+	numDeleted, err := deleteVoteScript.Run(lst.context, lst.cacheClient, keys,
+		vote.VoterID, vote.VoteID).Int()
 	if err != nil {
 		return err
 	}
 	if numDeleted == 0 {
-		return errors.New("attempted to delete non-existent item")
+		return ErrVoteNotFound
 	}
+	lst.publishEvent(events.VoteDeleted, vote.VoteID, vote)
 
 	return nil
 }
 
 func (lst *VoteList) DeleteAll() error {
-	pattern := RedisKeyPrefix + "*"
-	ks, _ := lst.cacheClient.Keys(lst.context, pattern).Result()
-	//Note delete can take a collection of keys.  In go we can
-	//expand a slice into individual arguments by using the ...
-	//operator
-	numDeleted, err := lst.cacheClient.Del(lst.context, ks...).Result()
+	//Subscribers need to know which poll each deleted vote was on, so
+	//read the votes before they are gone
+	voteList, err := lst.GetAllVotes()
 	if err != nil {
 		return err
 	}
 
+	pattern := lst.key(RedisKeyPrefix) + "*"
+	ks, err := storage.ScanKeys(lst.context, lst.cacheClient, pattern)
+	if err != nil {
+		return err
+	}
+
+	//The poll-voters hashes and the indexes only describe existing
+	//votes, so they go too
+	indexKs := []string{lst.key(RedisIndexKey)}
+	for _, prefix := range []string{lst.key(RedisPollVotersPrefix), lst.key(RedisPollVotesPrefix), lst.key(RedisVoterVotesPrefix)} {
+		prefixKs, err := storage.ScanKeys(lst.context, lst.cacheClient, prefix+"*")
+		if err != nil {
+			return err
+		}
+		indexKs = append(indexKs, prefixKs...)
+	}
+	if err := lst.cacheClient.Del(lst.context, indexKs...).Err(); err != nil {
+		return err
+	}
+
+	//Note delete can take a collection of keys.  In go we can
+	//expand a slice into individual arguments by using the ...
+	//operator.  Redis rejects a DEL without any keys, so only
+	//call it when there is something to delete
+	var numDeleted int64
+	if len(ks) > 0 {
+		numDeleted, err = lst.cacheClient.Del(lst.context, ks...).Result()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, vote := range voteList {
+		lst.publishEvent(events.VoteDeleted, vote.VoteID, vote)
+	}
+
 	if numDeleted != int64(len(ks)) {
 		return errors.New("one or more items could not be deleted")
 	}
@@ -246,7 +568,7 @@ func (lst *VoteList) GetSingleVoterResource(id uint) (Vote, error) {
 	// this is a good practice, return an error if the
 	// item does not exist
 	var vote Vote
-	pattern := redisKeyFromId(int(id))
+	pattern := lst.redisKeyFromId(int(id))
 	err := lst.getItemFromRedis(pattern, &vote)
 	if err != nil {
 		return Vote{}, err
@@ -267,20 +589,136 @@ discuss the concept of "paging" later, for now you can ignore)
 */
 func (lst *VoteList) GetAllVotes() ([]Vote, error) {
 
+	//The index keeps the ids in order, so the votes come back sorted
+	ids, err := storage.SetIds(lst.context, lst.cacheClient, lst.key(RedisIndexKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return lst.getVotes(ids)
+}
+
+// GetVotesPage returns up to limit votes with ids after cursor, along
+// with the cursor for the next page.  The returned cursor is empty once
+// the last page has been read
+func (lst *VoteList) GetVotesPage(cursor string, limit int) ([]Vote, string, error) {
+	return lst.getVotesPage(lst.key(RedisIndexKey), cursor, limit)
+}
+
+// Helper to load a page of the votes whose ids are in the sorted set key
+func (lst *VoteList) getVotesPage(key string, cursor string, limit int) ([]Vote, string, error) {
+	ids, next, err := storage.RangeIds(lst.context, lst.cacheClient, key, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	voteList, err := lst.getVotes(ids)
+	if err != nil {
+		return nil, "", err
+	}
+	return voteList, next, nil
+}
+
+// Helper to load the votes for a list of ids, skipping any that were
+// deleted since the ids were read.  A record that can't be decoded fails
+// the whole read rather than quietly going missing from the list
+func (lst *VoteList) getVotes(ids []uint) ([]Vote, error) {
+
+	docs, err := storage.GetDocuments(lst.jsonHelper, lst.key(RedisKeyPrefix), ids)
+	if err != nil {
+		return nil, err
+	}
+
 	//Now that we have the DB loaded, lets crate a slice
-	var voteList []Vote
+	voteList := make([]Vote, 0, len(docs))
 
-	//Lets query redis for all of the items
-	pattern := RedisKeyPrefix + "*"
-	ks, _ := lst.cacheClient.Keys(lst.context, pattern).Result()
-	for _, key := range ks {
+	for _, doc := range docs {
 		var vote Vote
-		err := lst.getItemFromRedis(key, &vote)
-		if err != nil {
-			return nil, err
+		if err := json.Unmarshal(doc.JSON, &vote); err != nil {
+			return nil, storage.CorruptRecord(doc.Key, err)
 		}
 		voteList = append(voteList, vote)
 	}
 
 	return voteList, nil
 }
+
+/*
+Get every vote that was cast on the poll with PollID = :id
+*/
+func (lst *VoteList) GetVotesForPoll(pollId uint) ([]Vote, error) {
+
+	//The poll-votes set already knows which votes belong to the poll
+	ids, err := storage.SetIds(lst.context, lst.cacheClient, lst.pollVotesKeyFromId(pollId))
+	if err != nil {
+		return nil, err
+	}
+
+	return lst.getVotes(ids)
+}
+
+/*
+Return every vote cast by the voter with VoterID = :id, in vote id order
+*/
+func (lst *VoteList) GetVotesForVoter(voterId uint) ([]Vote, error) {
+
+	ids, err := storage.SetIds(lst.context, lst.cacheClient, lst.voterVotesKeyFromId(voterId))
+	if err != nil {
+		return nil, err
+	}
+
+	return lst.getVotes(ids)
+}
+
+// GetVotesForPollPage returns a page of the votes cast on a poll, see
+// GetVotesPage for how the cursor works
+func (lst *VoteList) GetVotesForPollPage(pollId uint, cursor string, limit int) ([]Vote, string, error) {
+	return lst.getVotesPage(lst.pollVotesKeyFromId(pollId), cursor, limit)
+}
+
+// GetVotesForVoterPage returns a page of the votes cast by a voter, see
+// GetVotesPage for how the cursor works
+func (lst *VoteList) GetVotesForVoterPage(voterId uint, cursor string, limit int) ([]Vote, string, error) {
+	return lst.getVotesPage(lst.voterVotesKeyFromId(voterId), cursor, limit)
+}
+
+/*
+Delete every vote that was cast on the poll with PollID = :id.  Returns the
+number of votes that were deleted
+*/
+func (lst *VoteList) DeleteVotesForPoll(pollId uint) (int, error) {
+
+	pollVotes, err := lst.GetVotesForPoll(pollId)
+	if err != nil {
+		return 0, err
+	}
+
+	return lst.deleteVotes(pollVotes)
+}
+
+/*
+Delete every vote that was cast by the voter with VoterID = :id.  Returns the
+number of votes that were deleted
+*/
+func (lst *VoteList) DeleteVotesForVoter(voterId uint) (int, error) {
+
+	voterVotes, err := lst.GetVotesForVoter(voterId)
+	if err != nil {
+		return 0, err
+	}
+
+	return lst.deleteVotes(voterVotes)
+}
+
+// Helper to delete a batch of votes, returning how many were deleted
+func (lst *VoteList) deleteVotes(votes []Vote) (int, error) {
+	numDeleted := 0
+	for _, vote := range votes {
+		if err := lst.DeleteVote(vote.VoteID); err != nil {
+			return numDeleted, err
+		}
+		numDeleted++
+	}
+
+	return numDeleted, nil
+}
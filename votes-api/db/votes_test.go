@@ -0,0 +1,161 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"drexel.edu/common/storage"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestTallyVotes(t *testing.T) {
+	poll := Poll{
+		PollID:       1,
+		PollTitle:    "Pets",
+		PollQuestion: "Favorite pet?",
+		PollOptions: []PollOption{
+			{PollOptionID: 3, PollOptionText: "Fish"},
+			{PollOptionID: 1, PollOptionText: "Dog"},
+			{PollOptionID: 2, PollOptionText: "Cat", Retired: true},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		votes   []Vote
+		counts  []uint
+		pcts    []float64
+		invalid uint
+	}{
+		{"no votes", nil, []uint{0, 0, 0}, []float64{0, 0, 0}, 0},
+		{"one option", []Vote{{PollID: 1, VoteValue: 1}}, []uint{0, 1, 0}, []float64{0, 100, 0}, 0},
+		{"thirds are rounded", []Vote{
+			{PollID: 1, VoteValue: 1}, {PollID: 1, VoteValue: 2}, {PollID: 1, VoteValue: 3},
+		}, []uint{1, 1, 1}, []float64{33.33, 33.33, 33.33}, 0},
+		{"unknown options are invalid", []Vote{
+			{PollID: 1, VoteValue: 1}, {PollID: 1, VoteValue: 9}, {PollID: 1, VoteValue: 0},
+		}, []uint{0, 1, 0}, []float64{0, 100, 0}, 2},
+		{"other polls are ignored", []Vote{
+			{PollID: 1, VoteValue: 3}, {PollID: 2, VoteValue: 1},
+		}, []uint{1, 0, 0}, []float64{100, 0, 0}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := TallyVotes(poll, tt.votes)
+
+			if results.PollID != 1 || results.PollTitle != "Pets" || results.PollQuestion != "Favorite pet?" {
+				t.Errorf("results are labelled %d %q %q", results.PollID, results.PollTitle, results.PollQuestion)
+			}
+			var total uint
+			for _, count := range tt.counts {
+				total += count
+			}
+			if results.TotalVotes != total || results.InvalidVotes != tt.invalid {
+				t.Errorf("total %d and invalid %d, want %d and %d",
+					results.TotalVotes, results.InvalidVotes, total, tt.invalid)
+			}
+
+			//The results keep the order of the poll options
+			var counts []uint
+			var pcts []float64
+			for i, result := range results.Results {
+				option := poll.PollOptions[i]
+				if result.PollOptionID != option.PollOptionID || result.PollOptionText != option.PollOptionText ||
+					result.Retired != option.Retired {
+					t.Errorf("result %d is %+v, want option %+v", i, result, option)
+				}
+				counts = append(counts, result.Count)
+				pcts = append(pcts, result.Percentage)
+			}
+			if !reflect.DeepEqual(counts, tt.counts) || !reflect.DeepEqual(pcts, tt.pcts) {
+				t.Errorf("counts %v and percentages %v, want %v and %v", counts, pcts, tt.counts, tt.pcts)
+			}
+		})
+	}
+}
+
+// Listing this many votes should take less than benchListTarget
+const (
+	benchListCount  = 10000
+	benchListTarget = 200 * time.Millisecond
+)
+
+// BenchmarkGetAllVotes times listing 10k votes against the redis named by
+// REDIS_URL, or localhost:6379.  The votes are written under their own key
+// prefix, after REDIS_KEY_PREFIX if set, so nothing already stored is read
+// or touched, and they are removed again afterwards.  It is skipped if
+// redis can't be reached and fails if a listing is slower than the target
+func BenchmarkGetAllVotes(b *testing.B) {
+	addr := os.Getenv("REDIS_URL")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	cfg := RedisConfig{
+		Addr:      addr,
+		Password:  os.Getenv("REDIS_PASSWORD"),
+		KeyPrefix: fmt.Sprintf("%sbench-%d:", os.Getenv("REDIS_KEY_PREFIX"), time.Now().UnixNano()),
+	}
+
+	lst, err := NewWithConfig(cfg)
+	if err != nil {
+		b.Skipf("redis is not available at %s: %v", addr, err)
+	}
+	defer lst.Close()
+	defer deleteBenchKeys(b, lst)
+
+	_, err = lst.cacheClient.Pipelined(lst.context, func(pipe redis.Pipeliner) error {
+		for id := uint(1); id <= benchListCount; id++ {
+			voteJSON, err := json.Marshal(NewSampleVote(id, 1, id, 1))
+			if err != nil {
+				return err
+			}
+			pipe.Do(lst.context, "JSON.SET", lst.redisKeyFromId(int(id)), ".", string(voteJSON))
+			pipe.ZAdd(lst.context, lst.key(RedisIndexKey), &redis.Z{Score: float64(id), Member: id})
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatalf("seeding votes: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		voteList, err := lst.GetAllVotes()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(voteList) != benchListCount {
+			b.Fatalf("listed %d votes, want %d", len(voteList), benchListCount)
+		}
+	}
+	b.StopTimer()
+
+	if perOp := b.Elapsed() / time.Duration(b.N); perOp > benchListTarget {
+		b.Errorf("listing %d votes took %v, target is %v", benchListCount, perOp, benchListTarget)
+	}
+}
+
+// Helper to remove every key the benchmark wrote
+func deleteBenchKeys(b *testing.B, lst *VoteList) {
+	ks, err := storage.ScanKeys(lst.context, lst.cacheClient, lst.key("*"))
+	if err != nil {
+		b.Errorf("finding benchmark keys: %v", err)
+		return
+	}
+
+	for start := 0; start < len(ks); start += storage.ScanBatchSize {
+		end := start + storage.ScanBatchSize
+		if end > len(ks) {
+			end = len(ks)
+		}
+		if err := lst.cacheClient.Del(lst.context, ks[start:end]...).Err(); err != nil {
+			b.Errorf("deleting benchmark keys: %v", err)
+			return
+		}
+	}
+}
@@ -1,93 +1,181 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
-	"strconv"
-
+	"os/signal"
+	"syscall"
+	"time"
+
+	"drexel.edu/common/config"
+	"drexel.edu/common/logging"
+	"drexel.edu/common/tracing"
+	"drexel.edu/common/web"
 	"drexel.edu/votes-api/api"
+	"drexel.edu/votes-api/db"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-// Global variables to hold the command line flags to drive the todo CLI
-// application
-var (
-	hostFlag    string
-	portFlag    uint
-	cacheURL    string
-	voterAPIURL string
-	pollAPIURL  string
-)
-
-func processCmdLineFlags() {
-	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
-	flag.StringVar(&voterAPIURL, "voterapi", "http://localhost:1080", "Default endpoint for Voter API")
-	flag.StringVar(&pollAPIURL, "pollapi", "http://localhost:1080", "Default endpoint for Poll API")
-	flag.StringVar(&cacheURL, "c", "0.0.0.0:6379", "Default cache location")
-	flag.UintVar(&portFlag, "p", 1080, "Default Port")
-
-	flag.Parse()
+// What sets this service's configuration apart from the others
+var service = config.Service{
+	Keeps:      "votes",
+	SQLitePath: db.DefaultSQLitePath,
+	Calls: map[string]string{
+		config.VoterAPI: "http://localhost:1080",
+		config.PollAPI:  "http://localhost:1080",
+	},
+	//Names used before the services were configured the same way
+	RedisFlagAlias: "c",
+	RedisEnvAlias:  "CACHE_URL",
 }
 
-func envVarOrDefault(envVar string, defaultVal string) string {
-	envVal := os.Getenv(envVar)
-	if envVal != "" {
-		return envVal
+// main is the entry point for our todo API application.  It processes
+// the command line flags and then uses the db package to perform the
+// requested operation
+func main() {
+	//Settings come from the defaults, an optional config file, the
+	//environment and the command line, in that order
+	cfg, err := config.Load(service, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
 	}
-	return defaultVal
-}
 
-func setupParms() {
-	//first process any command line flags
-	processCmdLineFlags()
-
-	//now process any environment variables
-	cacheURL = envVarOrDefault("CACHE_URL", cacheURL)
-	voterAPIURL = envVarOrDefault("VOTER_API_URL", voterAPIURL)
-	pollAPIURL = envVarOrDefault("POLL_API_URL", pollAPIURL)
-	hostFlag = envVarOrDefault("RLAPI_HOST", hostFlag)
-
-	pfNew, err := strconv.Atoi(envVarOrDefault("RLAPI_PORT", fmt.Sprintf("%d", portFlag)))
-	//only update the port if we were able to convert the env var to an int, else
-	//we will use the default we got from the command line, or command line defaults
-	if err == nil {
-		portFlag = uint(pfNew)
+	if err := logging.Setup(cfg.LogFormat, cfg.LogLevel); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if cfg.File != "" {
+		slog.Info("Init", "configFile", cfg.File)
 	}
+	slog.Info("Init", "voterAPIURL", cfg.VoterAPIURL)
+	slog.Info("Init", "pollAPIURL", cfg.PollAPIURL)
+	slog.Info("Init", "store", cfg.Store)
+	switch cfg.Store {
+	case db.StoreRedis:
+		slog.Info("Init", "redis", cfg.Redis.Addr, "redisDB", cfg.Redis.DB, "redisTLS", cfg.Redis.TLS, "redisKeyPrefix", cfg.Redis.KeyPrefix)
+	case db.StoreSQLite:
+		slog.Info("Init", "sqlitePath", cfg.SQLitePath)
+	}
+	slog.Info("Init", "traces", cfg.Traces)
+	slog.Info("Init", "hostFlag", cfg.Host)
+	slog.Info("Init", "portFlag", cfg.Port)
 
-}
+	shutdownTracing, err := tracing.Setup(api.ServiceName, cfg.Traces, cfg.TracesFile)
+	if err != nil {
+		panic(err)
+	}
 
-// main is the entry point for our todo API application.  It processes
-// the command line flags and then uses the db package to perform the
-// requested operation
-func main() {
-	//this will allow the user to override key parameters and also setup defaults
-	setupParms()
-	log.Println("Init/cacheURL: " + cacheURL)
-	log.Println("Init/voterAPIURL: " + voterAPIURL)
-	log.Println("Init/pollAPIURL: " + pollAPIURL)
-	log.Println("Init/hostFlag: " + hostFlag)
-	log.Printf("Init/portFlag: %d", portFlag)
+	//Stop on SIGINT from a terminal or SIGTERM from docker
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	apiHandler, err := api.NewVoteAPI(cacheURL, voterAPIURL, pollAPIURL)
+	apiHandler, err := api.NewVoteAPI(cfg.Store, cfg.Redis, cfg.SQLitePath, cfg.VoterAPIURL, cfg.PollAPIURL)
 
 	if err != nil {
 		panic(err)
 	}
 
-	r := gin.Default()
+	//gin's own request logger is replaced by AccessLog, which logs in the
+	//same format as everything else
+	r := gin.New()
+	r.Use(gin.Recovery())
 	r.Use(cors.Default())
+	r.Use(api.Tracing())
+	r.Use(web.RequestID())
+	r.Use(web.AccessLog())
+	r.Use(api.Metrics())
+	r.Use(apiHandler.CountRequests())
 
+	r.POST("/votes", apiHandler.CreateVote)
 	r.POST("/votes/:id", apiHandler.AddVote)
 	r.GET("/votes", apiHandler.GetAllVotes)
 	r.GET("/votes/:id", apiHandler.GetVote)
 	r.GET("/votes/:id/voters/", apiHandler.GetVoterByVote)
 	r.GET("/votes/:id/polls/", apiHandler.GetPollByVote)
 	r.DELETE("/votes", apiHandler.DeleteAllVotes)
+	r.DELETE("/votes/:id", apiHandler.DeleteVote)
+
+	r.GET("/polls/:id/results", apiHandler.GetPollResults)
+	r.GET("/polls/:id/results/stream", apiHandler.StreamPollResults)
+	r.GET("/polls/:id/votes", apiHandler.GetPollVotes)
+	r.GET("/voters/:id/votes", apiHandler.GetVoterVotes)
+	r.DELETE("/polls/:id/votes", apiHandler.DeletePollVotes)
+	r.DELETE("/voters/:id/votes", apiHandler.DeleteVoterVotes)
+
+	// Liveness and readiness checks, readiness includes the voter and
+	// poll APIs
+	r.GET("/healthz", apiHandler.HealthCheck)
+	r.GET("/readyz", apiHandler.ReadinessCheck)
+
+	// Prometheus metrics
+	r.GET("/metrics", api.MetricsHandler())
 
 	//For now we will just support gets
-	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
-	r.Run(serverPath)
+	serverPath := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	srv := &http.Server{
+		Addr:         serverPath,
+		Handler:      r,
+		ReadTimeout:  cfg.Timeouts.Read,
+		WriteTimeout: cfg.Timeouts.Write,
+		IdleTimeout:  cfg.Timeouts.Idle,
+	}
+	srv.RegisterOnShutdown(apiHandler.EndStreams)
+
+	err = serve(ctx, srv, cfg.Timeouts.Shutdown)
+	if err != nil {
+		slog.Error("Error running server", "error", err)
+	}
+	stop()
+
+	//Only release the store and flush traces once nothing can use them
+	if err := apiHandler.Close(); err != nil {
+		slog.Error("Error closing store", "error", err)
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// serve runs srv until ctx is cancelled, then stops taking new
+// connections and gives the requests in flight up to shutdownTimeout to
+// finish
+func serve(ctx context.Context, srv *http.Server, shutdownTimeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down, draining requests", "timeout", shutdownTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }